@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/romanyx/watcher/deployer"
+)
+
+// adminServer returns a server for the admin listeners which marks its
+// requests as allowed to reach the read and control endpoints.
+func adminServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: opts.readHeaderTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return deployer.AdminContext(context.Background())
+		},
+	}
+}
+
+// adminListenAddr returns -admin-addr bound to localhost when it names
+// no host.
+func adminListenAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "localhost" + addr
+	}
+
+	return addr
+}
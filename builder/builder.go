@@ -0,0 +1,212 @@
+// Package builder runs the commands building a checked out deployment,
+// with the go tool, make, docker or a script, or nothing at all for
+// projects run from source.
+package builder
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// waitDelay is how long a step's output is waited for once the step exited
+// or was cancelled.
+const waitDelay = 10 * time.Second
+
+// Step is a command run in the build directory, the program first.
+type Step []string
+
+// Name names s by its command and subcommand, like "git clone".
+func (s Step) Name() string {
+	for i := 1; i < len(s); i++ {
+		if s[i] == "-c" {
+			i++
+			continue
+		}
+
+		if !strings.HasPrefix(s[i], "-") {
+			return s[0] + " " + s[i]
+		}
+	}
+
+	return s[0]
+}
+
+func (s Step) String() string {
+	return strings.Join(s, " ")
+}
+
+// Options describe a build.
+type Options struct {
+	// Binary is the name of the built executable, see ExeName, Head
+	// the commit built.
+	Binary string
+	Head   string
+}
+
+// Env returns the variables the steps of o run with: BINARY, the file
+// name of the executable, and SHA, the commit built.
+func (o Options) Env() []string {
+	return []string{"BINARY=" + ExeName(o.Binary), "SHA=" + o.Head}
+}
+
+// Builder builds a checked out deployment.
+type Builder interface {
+	// Steps returns the steps building o in the checked out directory,
+	// to be run with the variables of o.Env.
+	Steps(o Options) []Step
+}
+
+// Config holds the settings of the builders.
+type Config struct {
+	// MakeTarget is the target built by make, the default one when empty.
+	MakeTarget string
+
+	// DockerImage is the image built by docker and tagged with the
+	// commit, the binary name when empty.
+	DockerImage string
+
+	// Script is run by the shell to build.
+	Script string
+
+	// Install is run by the shell before the build, to install the
+	// dependencies of projects like "npm ci".
+	Install string
+}
+
+// New returns the builder named kind: go, make, docker, script or none.
+func New(kind string, c Config) (Builder, error) {
+	b, err := newKind(kind, c)
+	if err != nil || c.Install == "" {
+		return b, err
+	}
+
+	return installing{Builder: b, Install: c.Install}, nil
+}
+
+func newKind(kind string, c Config) (Builder, error) {
+	switch kind {
+	case "", "go":
+		return GoBuilder{}, nil
+	case "make":
+		return MakeBuilder{Target: c.MakeTarget}, nil
+	case "docker":
+		return DockerBuilder{Image: c.DockerImage}, nil
+	case "script":
+		if c.Script == "" {
+			return nil, errors.New("script builder without a script")
+		}
+		return ScriptBuilder{Script: c.Script}, nil
+	case "none":
+		return NoBuilder{}, nil
+	}
+
+	return nil, errors.Errorf("unknown builder %q", kind)
+}
+
+// GoBuilder builds with the go tool.
+type GoBuilder struct{}
+
+// Steps fetches the dependencies and builds Binary.
+func (GoBuilder) Steps(o Options) []Step {
+	return []Step{
+		{"go", "get", "-d"},
+		{"go", "build", "-o", ExeName(o.Binary)},
+	}
+}
+
+// MakeBuilder runs make, which is expected to produce $BINARY.
+type MakeBuilder struct {
+	Target string
+}
+
+// Steps runs make for Target.
+func (b MakeBuilder) Steps(o Options) []Step {
+	if b.Target == "" {
+		return []Step{{"make"}}
+	}
+
+	return []Step{{"make", b.Target}}
+}
+
+// DockerBuilder builds an image from the Dockerfile of the repository,
+// tagged with the commit. The run command starts it, like
+// "docker run --rm -p {{.Port}}:8080 app:{{.Sha}}".
+type DockerBuilder struct {
+	Image string
+}
+
+// Steps builds Image, or Binary, tagged with Head.
+func (b DockerBuilder) Steps(o Options) []Step {
+	image := b.Image
+	if image == "" {
+		image = o.Binary
+	}
+
+	return []Step{{"docker", "build", "-t", image + ":" + o.Head, "."}}
+}
+
+// ScriptBuilder runs Script with the shell, sh or cmd on Windows.
+type ScriptBuilder struct {
+	Script string
+}
+
+// Steps runs Script.
+func (b ScriptBuilder) Steps(o Options) []Step {
+	return []Step{append(shell(), b.Script)}
+}
+
+// NoBuilder builds nothing, for projects the run command starts from
+// source, like "node server.js".
+type NoBuilder struct{}
+
+// Steps returns no steps.
+func (NoBuilder) Steps(o Options) []Step {
+	return nil
+}
+
+// installing runs Install before the steps of Builder.
+type installing struct {
+	Builder
+	Install string
+}
+
+func (b installing) Steps(o Options) []Step {
+	return append([]Step{append(shell(), b.Install)}, b.Builder.Steps(o)...)
+}
+
+// Run runs step in dir with env, writing its output to out. The step is
+// started in its own process group so that the whole tree (git helpers,
+// compilers) is killed when ctx expires.
+func Run(ctx context.Context, dir string, env []string, out io.Writer, step Step) error {
+	return RunLimited(ctx, dir, env, out, Limits{}, step)
+}
+
+// RunLimited runs step like Run within the limits l.
+func RunLimited(ctx context.Context, dir string, env []string, out io.Writer, l Limits, step Step) error {
+	cmd := exec.CommandContext(ctx, step[0], step[1:]...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.Dir = dir
+	cmd.Env = env
+	// Output goes through pipes, which a grandchild escaping the group
+	// could keep open forever.
+	cmd.WaitDelay = waitDelay
+	isolate(cmd)
+
+	release, err := l.Start(cmd)
+	if err != nil {
+		return errors.Wrap(err, step.String())
+	}
+	err = cmd.Wait()
+	release()
+	if err != nil {
+		return errors.Wrap(err, step.String())
+	}
+
+	return nil
+}
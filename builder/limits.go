@@ -0,0 +1,90 @@
+package builder
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Limits bound the resources of a command and its descendants. The zero
+// value limits nothing.
+type Limits struct {
+	// Memory is the most bytes of memory used, 0 for no limit.
+	Memory int64
+	// CPU is the most CPUs used, fractions allowed, 0 for no limit.
+	CPU float64
+	// Nice is the niceness the command runs at, 0 leaves it.
+	Nice int
+	// IOIdle puts the command in the idle I/O class, getting disk time
+	// only when nobody else wants it.
+	IOIdle bool
+	// Cgroup is the cgroup v2 directory commands limited in Memory or CPU
+	// get their own cgroup in.
+	Cgroup string
+}
+
+// Enabled reports whether l limits anything.
+func (l Limits) Enabled() bool {
+	return l.Memory > 0 || l.CPU > 0 || l.Nice != 0 || l.IOIdle
+}
+
+// ParseLimits parses limits given as space separated fields like
+// "memory=512M cpu=1.5 nice=10 ioidle". Memory takes K, M and G suffixes.
+func ParseLimits(spec string) (Limits, error) {
+	var l Limits
+	for _, field := range strings.Fields(spec) {
+		key, value, _ := strings.Cut(field, "=")
+
+		var err error
+		switch key {
+		case "memory":
+			l.Memory, err = parseSize(value)
+		case "cpu":
+			l.CPU, err = strconv.ParseFloat(value, 64)
+			if err == nil && l.CPU <= 0 {
+				err = errors.New("must be positive")
+			}
+		case "nice":
+			l.Nice, err = strconv.Atoi(value)
+			if err == nil && (l.Nice < 0 || l.Nice > 19) {
+				err = errors.New("must be 0 to 19")
+			}
+		case "ioidle":
+			l.IOIdle = true
+		default:
+			return Limits{}, errors.Errorf("unknown limit %q", key)
+		}
+		if err != nil {
+			return Limits{}, errors.Wrapf(err, "limit %s", key)
+		}
+	}
+
+	return l, nil
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix.
+func parseSize(s string) (int64, error) {
+	shift := uint(0)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, errors.New("must be positive")
+	}
+
+	return n << shift, nil
+}
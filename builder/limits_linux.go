@@ -0,0 +1,127 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// cpuPeriod is the cgroup CPU accounting period in microseconds.
+	cpuPeriod = 100000
+
+	// ioprioWhoProcess and ioprioWhoPgrp select what ioprio_set applies to.
+	ioprioWhoProcess = 1
+	ioprioWhoPgrp    = 2
+
+	// ioprioIdle is the idle I/O class shifted into place.
+	ioprioIdle = 3 << 13
+)
+
+// Start starts cmd within l. Memory and CPU are bounded by a new cgroup,
+// so the limits cover everything cmd forks, niceness and the I/O class are
+// set for its process group. The returned function removes the cgroup and
+// must be called once cmd exited.
+func (l Limits) Start(cmd *exec.Cmd) (func(), error) {
+	release := func() {}
+
+	if l.Memory > 0 || l.CPU > 0 {
+		dir, err := l.cgroup(filepath.Base(cmd.Path))
+		if err != nil {
+			return nil, err
+		}
+		release = func() { os.Remove(dir) }
+
+		fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+		if err != nil {
+			release()
+			return nil, errors.Wrap(err, "open cgroup")
+		}
+		defer syscall.Close(fd)
+
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = fd
+	}
+
+	if err := cmd.Start(); err != nil {
+		release()
+		return nil, err
+	}
+
+	pid := cmd.Process.Pid
+	group := cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid
+
+	if l.Nice != 0 {
+		which := syscall.PRIO_PROCESS
+		if group {
+			which = syscall.PRIO_PGRP
+		}
+		if err := syscall.Setpriority(which, pid, l.Nice); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			release()
+			return nil, errors.Wrap(err, "set niceness")
+		}
+	}
+
+	if l.IOIdle {
+		who := ioprioWhoProcess
+		if group {
+			who = ioprioWhoPgrp
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, uintptr(who), uintptr(pid), ioprioIdle); errno != 0 {
+			cmd.Process.Kill()
+			cmd.Wait()
+			release()
+			return nil, errors.Wrap(errno, "set I/O class")
+		}
+	}
+
+	return release, nil
+}
+
+// cgroup creates a cgroup for a command named name under l.Cgroup with the
+// memory and CPU limits of l.
+func (l Limits) cgroup(name string) (string, error) {
+	if err := os.MkdirAll(l.Cgroup, 0755); err != nil {
+		return "", errors.Wrap(err, "create cgroup root")
+	}
+	// Fails when already enabled or not delegated, writing the limits
+	// below tells which.
+	ioutil.WriteFile(filepath.Join(l.Cgroup, "cgroup.subtree_control"), []byte("+memory +cpu"), 0644)
+
+	dir := filepath.Join(l.Cgroup, fmt.Sprintf("%s-%d", name, time.Now().UnixNano()))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "create cgroup")
+	}
+
+	write := func(file, value string) error {
+		err := ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+		return errors.Wrapf(err, "write %s", file)
+	}
+
+	if l.Memory > 0 {
+		if err := write("memory.max", fmt.Sprint(l.Memory)); err != nil {
+			os.Remove(dir)
+			return "", err
+		}
+	}
+
+	if l.CPU > 0 {
+		if err := write("cpu.max", fmt.Sprintf("%d %d", int64(l.CPU*cpuPeriod), cpuPeriod)); err != nil {
+			os.Remove(dir)
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
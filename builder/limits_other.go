@@ -0,0 +1,23 @@
+//go:build !linux
+
+package builder
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// Start starts cmd within l. Limits need Linux, elsewhere only the zero
+// Limits start cmd.
+func (l Limits) Start(cmd *exec.Cmd) (func(), error) {
+	if l.Enabled() {
+		return nil, errors.New("resource limits are supported on Linux only")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return func() {}, nil
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package builder
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// ExeName returns the file name of the executable built as name.
+func ExeName(name string) string {
+	return name
+}
+
+// shell returns the command running a script given as its last argument.
+func shell() Step {
+	return Step{"sh", "-c"}
+}
+
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
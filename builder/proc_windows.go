@@ -0,0 +1,33 @@
+package builder
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ExeName returns the file name of the executable built as name.
+func ExeName(name string) string {
+	return name + ".exe"
+}
+
+// shell returns the command running a script given as its last argument.
+func shell() Step {
+	return Step{"cmd", "/C"}
+}
+
+// isolate starts cmd in its own process group, it is killed with its
+// descendants.
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+	cmd.Cancel = func() error {
+		out, err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).CombinedOutput()
+		if err != nil {
+			return errors.Wrapf(err, "taskkill: %s", out)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/romanyx/watcher/client"
+)
+
+// commands are the subcommands talking to a running watcher.
+var commands = map[string]func(c *client.Client, args []string) error{
+	"deploy":   cmdDeploy,
+	"rollback": cmdRollback,
+	"status":   cmdStatus,
+	"logs":     cmdLogs,
+}
+
+// runSubcommand runs the subcommand name with args and returns the exit
+// code.
+func runSubcommand(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	addr := fs.String("addr", envOr("WATCHER_ADDR", "https://localhost"), "URL of the watcher, or unix:PATH of its -admin-socket")
+	token := fs.String("secret", os.Getenv("WATCHER_SECRET"), "Secret of the watcher")
+	app := fs.String("app", "", "App to talk about when the watcher runs several")
+	insecure := fs.Bool("insecure", false, "Skip verifying the TLS certificate of the watcher")
+
+	follow, dry := false, false
+	switch name {
+	case "logs":
+		fs.BoolVar(&follow, "f", false, "Follow the output of the deployment in progress")
+	case "deploy":
+		fs.BoolVar(&dry, "dry-run", false, "Build and check the head without switching traffic, waiting for the outcome")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	c := client.New(*addr, *token)
+	c.App = *app
+	if *insecure {
+		c.HTTP.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	rest := fs.Args()
+	if follow {
+		rest = append([]string{"-f"}, rest...)
+	}
+	if dry {
+		rest = append([]string{"-dry-run"}, rest...)
+	}
+
+	if err := commands[name](c, rest); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return def
+}
+
+// printResponse sends a request and copies the response to stdout.
+func printResponse(c *client.Client, method, path string) error {
+	resp, err := c.Do(context.Background(), method, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(os.Stdout, resp.Body)
+	fmt.Println()
+
+	return nil
+}
+
+func cmdDeploy(c *client.Client, args []string) error {
+	dry := len(args) > 0 && args[0] == "-dry-run"
+	if dry {
+		args = args[1:]
+	}
+
+	if len(args) != 1 {
+		return errors.New("usage: watcher deploy [flags] <sha|ref>")
+	}
+
+	if dry {
+		d, err := c.DryRun(context.Background(), args[0])
+		if err != nil {
+			return err
+		}
+
+		return json.NewEncoder(os.Stdout).Encode(d)
+	}
+
+	head, err := c.Deploy(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println("Deploying", head)
+
+	return nil
+}
+
+func cmdRollback(c *client.Client, args []string) error {
+	head, err := c.Rollback(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Println("Rolled back to", head)
+
+	return nil
+}
+
+func cmdStatus(c *client.Client, args []string) error {
+	return printResponse(c, http.MethodGet, "/_status?format=text")
+}
+
+// cmdLogs prints the logs of a deployment, the serving one by default, or
+// with -f follows the deployment in progress.
+func cmdLogs(c *client.Client, args []string) error {
+	if len(args) > 0 && args[0] == "-f" {
+		return follow(c)
+	}
+
+	var head string
+	if len(args) > 0 {
+		head = args[0]
+	} else {
+		s, err := c.Status(context.Background())
+		if err != nil {
+			return err
+		}
+		head = s.Head
+	}
+
+	return printResponse(c, http.MethodGet, "/_logs/"+url.PathEscape(head))
+}
+
+// follow prints the output of the deployment in progress until it ends.
+func follow(c *client.Client) error {
+	first := true
+	err := c.StreamLogs(context.Background(), func(ev client.LogEvent) error {
+		switch {
+		case !first:
+			fmt.Println(ev.Line)
+		case ev.Head == "":
+			fmt.Println("No deployment in progress")
+		default:
+			fmt.Printf("==> %s\n", ev.Head)
+		}
+		first = false
+
+		return nil
+	})
+	if err == io.ErrUnexpectedEOF {
+		// Dropped for being slow, the deployment goes on.
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,252 @@
+// Package client drives a running watcher through its admin API: deploys,
+// rollbacks, status, deployment history and live build output.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Client calls the admin API of a watcher.
+type Client struct {
+	// App names the app talked about when the watcher runs several.
+	App string
+
+	// HTTP sends the requests. Its Transport is an *http.Transport which
+	// may be adjusted, for a TLS config for instance.
+	HTTP *http.Client
+
+	base  string
+	token string
+}
+
+// New returns a client of the watcher at baseURL, like
+// https://deploy.example.com, or unix:PATH of its -admin-socket. token is
+// sent as bearer token, the -secret of the watcher unless -read-auth and
+// -control-auth say otherwise.
+func New(baseURL, token string) *Client {
+	c := &Client{base: strings.TrimSuffix(baseURL, "/"), token: token}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if path := strings.TrimPrefix(baseURL, "unix:"); path != baseURL {
+		c.base = "http://watcher"
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+	}
+	c.HTTP = &http.Client{Transport: transport}
+
+	return c
+}
+
+// Error is a request the watcher answered with a non-2xx status.
+type Error struct {
+	Method, Path string
+	StatusCode   int
+	Message      string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Do sends a request for path, which may have a query, and returns the
+// response. Statuses other than 2xx are returned as *Error.
+func (c *Client) Do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	u := c.base + path
+	if c.App != "" {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		u += sep + "app=" + url.QueryEscape(c.App)
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "new request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "request")
+	}
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &Error{Method: method, Path: path, StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(msg))}
+	}
+
+	return resp, nil
+}
+
+// text sends a request and returns the plain text answer.
+func (c *Client) text(ctx context.Context, method, path string, body io.Reader) (string, error) {
+	resp, err := c.Do(ctx, method, path, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "read body")
+	}
+
+	return string(b), nil
+}
+
+// decode sends a request and decodes the JSON answer into v.
+func (c *Client) decode(ctx context.Context, method, path string, body io.Reader, v interface{}) error {
+	resp, err := c.Do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(v), "decode body")
+}
+
+func refBody(ref string) io.Reader {
+	body, _ := json.Marshal(map[string]string{"ref": ref})
+	return bytes.NewReader(body)
+}
+
+// Deploy queues ref, a commit or a branch, for deployment and returns the
+// commit it resolved to. The deployment goes on in the background, Status,
+// History and StreamLogs follow it.
+func (c *Client) Deploy(ctx context.Context, ref string) (string, error) {
+	msg, err := c.text(ctx, http.MethodPost, "/_deploy", refBody(ref))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(msg, "Deploying "), nil
+}
+
+// DryRun builds and checks ref without switching traffic to it and
+// returns the outcome once done.
+func (c *Client) DryRun(ctx context.Context, ref string) (*Deployment, error) {
+	var d Deployment
+	if err := c.decode(ctx, http.MethodPost, "/_deploy?dry_run=1", refBody(ref), &d); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// Rollback switches traffic back to the build deployed before the current
+// one and returns the commit now serving.
+func (c *Client) Rollback(ctx context.Context) (string, error) {
+	msg, err := c.text(ctx, http.MethodPost, "/_rollback", nil)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(msg, "Rolled back to "), nil
+}
+
+// Status returns the status of the watcher.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var s Status
+	if err := c.decode(ctx, http.MethodGet, "/_status", nil, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// History returns the deployment attempts matching q, newest first.
+func (c *Client) History(ctx context.Context, q HistoryQuery) (*HistoryPage, error) {
+	v := url.Values{}
+	for key, value := range map[string]string{"sha": q.SHA, "trigger": q.Trigger, "result": q.Result} {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Before > 0 {
+		v.Set("before", strconv.FormatInt(q.Before, 10))
+	}
+
+	path := "/_deployments"
+	if len(v) > 0 {
+		path += "?" + v.Encode()
+	}
+
+	var page HistoryPage
+	if err := c.decode(ctx, http.MethodGet, path, nil, &page); err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+// LogEvent is an event of StreamLogs. The first one names the deployment
+// in Head, empty when none is in progress, the following ones carry its
+// output a line at a time.
+type LogEvent struct {
+	Head string
+	Line string
+}
+
+// StreamLogs calls fn with the output of the deployment in progress until
+// it ends, ctx is done or fn fails. A client too slow to keep up is
+// dropped by the watcher and StreamLogs returns early with
+// io.ErrUnexpectedEOF.
+func (c *Client) StreamLogs(ctx context.Context, fn func(LogEvent) error) error {
+	resp, err := c.Do(ctx, http.MethodGet, "/_deployments/current/logs/stream", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	event := ""
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+			if event == "end" {
+				return nil
+			}
+		case strings.HasPrefix(line, "data: "):
+			data := strings.TrimPrefix(line, "data: ")
+			ev := LogEvent{Line: data}
+			if event == "deployment" {
+				ev = LogEvent{Head: data}
+			}
+			if err := fn(ev); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return errors.Wrap(err, "read stream")
+	}
+
+	return io.ErrUnexpectedEOF
+}
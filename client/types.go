@@ -0,0 +1,106 @@
+package client
+
+import (
+	"time"
+)
+
+// Status is the status of a watcher as reported by /_status.
+type Status struct {
+	Head       string    `json:"head"`
+	Side       int       `json:"side"`
+	Dir        string    `json:"dir"`
+	Port       int       `json:"port"`
+	Addr       string    `json:"addr"`
+	Uptime     float64   `json:"uptime_seconds"`
+	Replicas   int       `json:"replicas,omitempty"`
+	State      string    `json:"state"`
+	StateHead  string    `json:"state_head,omitempty"`
+	StateSince time.Time `json:"state_since"`
+	Deployed   time.Time `json:"deployed,omitempty"`
+	Failure    string    `json:"failure,omitempty"`
+
+	Queued     string `json:"queued,omitempty"`
+	QueueDepth int    `json:"queue_depth"`
+	Held       bool   `json:"held"`
+
+	Retained    []Retained `json:"retained"`
+	Crashes     int        `json:"crashes"`
+	Consecutive int        `json:"consecutive_crashes"`
+	Canary      *Instance  `json:"canary,omitempty"`
+	Staged      *Instance  `json:"staged,omitempty"`
+	RolledBack  string     `json:"rolled_back,omitempty"`
+	Bans        []Ban      `json:"bans"`
+
+	Watcher BuildInfo `json:"watcher"`
+}
+
+// Instance is a running canary or a build staged for approval.
+type Instance struct {
+	Head   string  `json:"head"`
+	Side   int     `json:"side"`
+	Port   int     `json:"port,omitempty"`
+	Addr   string  `json:"addr,omitempty"`
+	Uptime float64 `json:"uptime_seconds,omitempty"`
+}
+
+// Retained is a build kept on disk for rollbacks.
+type Retained struct {
+	Head  string    `json:"head"`
+	Built time.Time `json:"built"`
+}
+
+// Ban is a client banned from the webhook.
+type Ban struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+// BuildInfo describes the build of the watcher.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	Go        string `json:"go"`
+}
+
+// Deployment is one deployment attempt.
+type Deployment struct {
+	ID      int64     `json:"id"`
+	Head    string    `json:"sha"`
+	Trigger string    `json:"trigger"`
+	Started time.Time `json:"started"`
+	Ended   time.Time `json:"ended"`
+	Result  string    `json:"result"`
+	Stages  []Stage   `json:"stages"`
+	Error   string    `json:"error,omitempty"`
+	Output  string    `json:"output,omitempty"`
+	DryRun  bool      `json:"dry_run,omitempty"`
+	Image   string    `json:"image,omitempty"`
+
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Stage is a timed stage of a deployment.
+type Stage struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// HistoryQuery filters History. Zero fields don't filter.
+type HistoryQuery struct {
+	SHA, Trigger, Result string
+
+	// Limit is the most deployments returned, 50 by default and 500 at
+	// most.
+	Limit int
+
+	// Before continues a listing from HistoryPage.Next.
+	Before int64
+}
+
+// HistoryPage is a page of deployments, newest first. Next is set when
+// more may follow, to be passed as HistoryQuery.Before.
+type HistoryPage struct {
+	Deployments []Deployment `json:"deployments"`
+	Next        int64        `json:"next,omitempty"`
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// configEntry is one setting of the config file. Its key is the name of
+// the flag it sets.
+type configEntry struct {
+	line   int
+	key    string
+	values []string
+	list   bool
+}
+
+// loadConfig applies the settings of the -config file at path to the flags
+// of fs not in given, the ones set on the command line or in the environment.
+//
+// The file is YAML of the shape the flags have: every key is a flag name,
+// nested keys are joined with "-" (tls: {cert: ...} sets -tls-cert) and
+// flags which may be repeated or take comma separated values take lists.
+func loadConfig(fs *flag.FlagSet, path string, given map[string]bool) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "read config")
+	}
+
+	entries, err := parseConfig(string(data))
+	if err != nil {
+		return errors.Wrap(err, path)
+	}
+
+	seen := map[string]int{}
+	for _, e := range entries {
+		f := fs.Lookup(e.key)
+		if f == nil || e.key == "config" {
+			return errors.Errorf("%s:%d: unknown setting %q", path, e.line, e.key)
+		}
+
+		// Lists given for comma separated flags are joined.
+		_, repeatable := f.Value.(*stringList)
+		values := e.values
+		if e.list && !repeatable {
+			values = []string{strings.Join(values, ",")}
+		}
+
+		if prev, ok := seen[e.key]; ok && !repeatable {
+			return errors.Errorf("%s:%d: %s is already set on line %d", path, e.line, e.key, prev)
+		}
+		seen[e.key] = e.line
+
+		if given[e.key] {
+			continue
+		}
+
+		for _, v := range values {
+			if err := f.Value.Set(v); err != nil {
+				return errors.Errorf("%s:%d: invalid value %q for %s: %v", path, e.line, v, e.key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseConfig parses the block style YAML subset config files use: maps,
+// lists of scalars, flow lists like [a, b], quoted strings and comments.
+func parseConfig(data string) ([]configEntry, error) {
+	type level struct {
+		indent int
+		key    string
+	}
+
+	var (
+		entries []configEntry
+		stack   []level
+		// open is the key given without a value last, which list items
+		// belong to.
+		open *level
+	)
+
+	for i, raw := range strings.Split(data, "\n") {
+		n := i + 1
+
+		line := strings.TrimRight(stripComment(raw), " \r")
+		if strings.TrimSpace(line) == "" || strings.TrimSpace(line) == "---" {
+			continue
+		}
+
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+		if strings.HasPrefix(content, "\t") {
+			return nil, errors.Errorf("line %d: indent with spaces, not tabs", n)
+		}
+
+		if content == "-" || strings.HasPrefix(content, "- ") {
+			if open == nil || indent < open.indent {
+				return nil, errors.Errorf("line %d: list item without a key", n)
+			}
+
+			v, err := unquote(strings.TrimSpace(strings.TrimPrefix(content, "-")))
+			if err != nil {
+				return nil, errors.Errorf("line %d: %v", n, err)
+			}
+
+			if k := len(entries) - 1; k < 0 || entries[k].key != open.key || !entries[k].list {
+				entries = append(entries, configEntry{line: n, key: open.key, list: true})
+			}
+			last := &entries[len(entries)-1]
+			last.values = append(last.values, v)
+			continue
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		colon := strings.Index(content, ":")
+		if colon < 1 {
+			return nil, errors.Errorf("line %d: want key: value", n)
+		}
+
+		key := strings.Replace(strings.TrimSpace(content[:colon]), "_", "-", -1)
+		value := strings.TrimSpace(content[colon+1:])
+
+		var path []string
+		for _, l := range stack {
+			path = append(path, l.key)
+		}
+		full := strings.Join(append(path, key), "-")
+
+		if value == "" {
+			stack = append(stack, level{indent: indent, key: key})
+			open = &level{indent: indent, key: full}
+			continue
+		}
+		open = nil
+
+		e := configEntry{line: n, key: full}
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			e.list = true
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = strings.TrimSpace(item); item == "" {
+					continue
+				}
+
+				v, err := unquote(item)
+				if err != nil {
+					return nil, errors.Errorf("line %d: %v", n, err)
+				}
+				e.values = append(e.values, v)
+			}
+		} else {
+			v, err := unquote(value)
+			if err != nil {
+				return nil, errors.Errorf("line %d: %v", n, err)
+			}
+			e.values = []string{v}
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// stripComment cuts a # comment off line, leaving # in quotes alone.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// unquote returns the value of a plain, single or double quoted scalar.
+func unquote(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '"' {
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", errors.Errorf("bad quoted string %s", s)
+		}
+		return v, nil
+	}
+
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+
+	return s, nil
+}
@@ -0,0 +1,111 @@
+package deployer
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+const adminPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.App}} · watcher</title>
+<style>
+body { font: 14px/1.4 sans-serif; margin: 2em; max-width: 70em; }
+table { border-collapse: collapse; width: 100%; }
+td, th { text-align: left; padding: .2em .6em; border-bottom: 1px solid #ddd; }
+code, pre { font-family: monospace; }
+pre { background: #111; color: #ddd; padding: 1em; height: 25em; overflow: auto; }
+.failure, .failed { color: #b00; }
+.success { color: #070; }
+button { margin-right: .5em; }
+</style>
+</head>
+<body>
+<h1>{{.App}} <small>{{.Repo}}</small></h1>
+
+<h2>State</h2>
+<table>
+<tr><th>Head</th><td><code>{{.Status.Head}}</code></td></tr>
+<tr><th>Side</th><td>{{.Status.Side}}</td></tr>
+<tr><th>State</th><td class="{{.Status.State}}">{{.Status.State}} {{with .Status.StateHead}}<code>{{.}}</code>{{end}} since {{.Status.StateSince.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>Uptime</th><td>{{.Uptime}}</td></tr>
+{{with .Status.Queued}}<tr><th>Queued</th><td><code>{{.}}</code></td></tr>{{end}}
+{{if .Status.Held}}<tr><th>Held</th><td>deployments are paused or outside the window</td></tr>{{end}}
+{{with .Status.Failure}}<tr><th>Failure</th><td class="failure">{{.}}</td></tr>{{end}}
+{{with .Status.Canary}}<tr><th>Canary</th><td><code>{{.Head}}</code> on side {{.Side}}</td></tr>{{end}}
+{{with .Status.Staged}}<tr><th>Staged</th><td><code>{{.Head}}</code> waits for approval</td></tr>{{end}}
+</table>
+
+<h2>Actions</h2>
+<p>
+<input id="ref" placeholder="branch, tag or sha">
+<button onclick="act('/_deploy', JSON.stringify({ref: document.getElementById('ref').value}))">Deploy</button>
+<button onclick="act('/_rollback')">Roll back</button>
+<button onclick="act('/_pause')">Pause</button>
+<button onclick="act('/_resume')">Resume</button>
+{{if .Status.Staged}}<button onclick="act('/_approve')">Approve</button>{{end}}
+{{if .Status.Canary}}<button onclick="act('/_canary/promote')">Promote canary</button><button onclick="act('/_canary/abort')">Abort canary</button>{{end}}
+<span id="result"></span>
+</p>
+
+<h2>Live log</h2>
+<pre id="log"></pre>
+
+<h2>History</h2>
+<table>
+<tr><th>#</th><th>Sha</th><th>Trigger</th><th>Started</th><th>Duration</th><th>Result</th><th>Error</th></tr>
+{{range .History}}
+<tr><td>{{.ID}}</td><td><code title="{{.Head}}">{{short .Head}}</code></td><td>{{.Trigger}}</td><td>{{.Started.Format "2006-01-02 15:04:05"}}</td><td>{{duration .}}</td><td class="{{.Result}}">{{.Result}}</td><td>{{.Error}}</td></tr>
+{{end}}
+</table>
+
+<script>
+var app = {{.App}};
+function url(path) { return path + '?app=' + encodeURIComponent(app); }
+function act(path, body) {
+	fetch(url(path), {method: 'POST', body: body, credentials: 'same-origin'})
+		.then(function(r) { return r.text(); })
+		.then(function(t) { document.getElementById('result').textContent = t; setTimeout(function() { location.reload(); }, 1500); });
+}
+var log = document.getElementById('log');
+var events = new EventSource(url('/_deployments/current/logs/stream'));
+events.addEventListener('deployment', function(e) { log.textContent = e.data ? '==> ' + e.data + '\n' : ''; });
+events.addEventListener('log', function(e) { log.textContent += e.data + '\n'; log.scrollTop = log.scrollHeight; });
+events.addEventListener('end', function() { events.close(); });
+</script>
+</body>
+</html>
+`
+
+var adminTemplate = template.Must(template.New("admin").Funcs(template.FuncMap{
+	"short": shortSha,
+	"duration": func(a attempt) string {
+		return a.Ended.Sub(a.Started).Round(time.Second).String()
+	},
+}).Parse(adminPage))
+
+// serveAdmin serves the dashboard of m. Browsers authenticate with the
+// basic= credentials of -read-auth and -control-auth.
+func (m *Manager) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	s := m.status()
+
+	uptime := "-"
+	if s.Uptime > 0 {
+		uptime = (time.Duration(s.Uptime) * time.Second).String()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := adminTemplate.Execute(w, struct {
+		App, Repo string
+		Status    statusReport
+		Uptime    string
+		History   []attempt
+	}{m.name, m.repo, s, uptime, m.journal.list(journalFilter{limit: 20})})
+	if err != nil {
+		logger(subWatcher).Error("Execute admin page failed", "err", err)
+	}
+}
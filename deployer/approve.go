@@ -0,0 +1,63 @@
+package deployer
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var errNothingStaged = errors.New("no build waiting for approval")
+
+// staged is a started and healthy build waiting for approval. It is
+// discarded when timer fires first.
+type staged struct {
+	*candidate
+	timer *time.Timer
+}
+
+// stage holds c back from traffic until it is approved. The caller must
+// hold m.mu.
+func (m *Manager) stage(c *candidate) {
+	s := &staged{candidate: c}
+	s.timer = time.AfterFunc(config().ApproveTimeout, func() {
+		m.mu.Lock()
+		defer m.unlock()
+
+		if m.staged != s {
+			return
+		}
+		m.staged = nil
+
+		m.drop(c)
+		m.failure = "approval of " + c.deployment.head + " timed out"
+		c.logger(subSupervisor).Warn("Approval timed out")
+	})
+
+	m.staged = s
+	c.logger(subSupervisor).Info("Build waits for approval")
+}
+
+// approve lets the staged build serve, as a canary when canaries are
+// enabled.
+func (m *Manager) approve() (string, error) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	s := m.staged
+	if s == nil {
+		return "", errNothingStaged
+	}
+	s.timer.Stop()
+	m.staged = nil
+
+	if canaryEnabled() {
+		m.startCanary(s.candidate)
+		s.logger(subSupervisor).Info("Build approved, canary started")
+		return s.deployment.head, nil
+	}
+
+	m.promote(s.candidate)
+	m.logger(subSupervisor).Info("Build approved")
+
+	return m.last, nil
+}
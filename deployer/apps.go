@@ -0,0 +1,184 @@
+package deployer
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// App describes one app given with -app: where its commits come from and
+// the hosts it is served on. State and Journal are the files its state
+// and deployments are kept in, empty for none. Logs is the directory
+// within -logs-dir its deployment logs are kept in, empty for -logs-dir
+// itself.
+type App struct {
+	Name, Repo, Binary, Branch, Ports string
+	Hosts                             []string
+
+	// Redeploy and Restart override -redeploy-schedule and
+	// -restart-schedule.
+	Redeploy, Restart string
+
+	State, Journal, Logs string
+}
+
+// ParseApps parses -app specs of space separated key=value fields:
+// name and repo are required, binary defaults to name, branch and ports
+// to those of def, hosts is a comma separated list of the hosts the app
+// is served on. redeploy and restart override -redeploy-schedule and
+// -restart-schedule, with underscores for the spaces of the cron
+// expression. Without specs def is the only app. Apps beside the first
+// keep their state and journal next to the files of def and their logs in
+// a directory of their own.
+func ParseApps(list []string, def App) ([]App, error) {
+	if len(list) == 0 {
+		return []App{def}, nil
+	}
+
+	var specs []App
+	names := map[string]bool{}
+	for _, s := range list {
+		spec := App{Branch: def.Branch, Ports: def.Ports}
+		for _, field := range strings.Fields(s) {
+			i := strings.Index(field, "=")
+			if i < 1 {
+				return nil, errors.Errorf("app %q: want key=value, got %q", s, field)
+			}
+
+			v := field[i+1:]
+			switch field[:i] {
+			case "name":
+				spec.Name = v
+			case "repo":
+				spec.Repo = v
+			case "binary":
+				spec.Binary = v
+			case "branch":
+				spec.Branch = v
+			case "ports":
+				spec.Ports = v
+			case "redeploy":
+				spec.Redeploy = strings.Replace(v, "_", " ", -1)
+			case "restart":
+				spec.Restart = strings.Replace(v, "_", " ", -1)
+			case "hosts":
+				for _, h := range strings.Split(v, ",") {
+					if h = strings.TrimSpace(h); h != "" {
+						spec.Hosts = append(spec.Hosts, strings.ToLower(h))
+					}
+				}
+			default:
+				return nil, errors.Errorf("app %q: unknown key %q", s, field[:i])
+			}
+		}
+
+		if spec.Name == "" || spec.Repo == "" {
+			return nil, errors.Errorf("app %q: name and repo are required", s)
+		}
+
+		if names[spec.Name] {
+			return nil, errors.Errorf("app %q: name %s is used twice", s, spec.Name)
+		}
+		names[spec.Name] = true
+
+		if spec.Binary == "" {
+			spec.Binary = spec.Name
+		}
+
+		for _, expr := range []string{spec.Redeploy, spec.Restart} {
+			if expr == "" {
+				continue
+			}
+			if _, err := parseCron(expr); err != nil {
+				return nil, errors.Wrapf(err, "app %s", spec.Name)
+			}
+		}
+
+		specs = append(specs, spec)
+	}
+
+	for i := range specs {
+		specs[i].State, specs[i].Journal = def.State, def.Journal
+		if len(specs) == 1 {
+			continue
+		}
+		if def.State != "" {
+			specs[i].State += "." + specs[i].Name
+		}
+		if def.Journal != "" {
+			specs[i].Journal += "." + specs[i].Name
+		}
+		specs[i].Logs = specs[i].Name
+	}
+
+	return specs, nil
+}
+
+// byName returns the app called name.
+func (s *Set) byName(name string) *Manager {
+	for _, m := range s.list {
+		if m.name == name {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// forRequest returns the app serving r: the one named by ?app= on watcher
+// endpoints, the one of the host of r, or else the first.
+func (s *Set) forRequest(r *http.Request) *Manager {
+	if strings.HasPrefix(r.URL.Path, "/_") {
+		if m := s.byName(r.URL.Query().Get("app")); m != nil {
+			return m
+		}
+	}
+
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if m, ok := s.byHost[host]; ok {
+		return m
+	}
+
+	return s.list[0]
+}
+
+// forPush returns the app deploying pushes of ref to repo, nil if there is
+// none.
+func (s *Set) forPush(repo, ref string) *Manager {
+	for _, m := range s.list {
+		if strings.EqualFold(m.repo, repo) && ref == "refs/heads/"+m.branch {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// repoAllowed reports whether pushes of repo are accepted by -webhook-repos.
+// Patterns match like path.Match, ignoring case.
+func repoAllowed(repo string) bool {
+	if config().WebhookRepos == "" {
+		return true
+	}
+
+	for _, pattern := range strings.Split(config().WebhookRepos, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if ok, _ := path.Match(pattern, strings.ToLower(repo)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ServeHTTP serves r with the app it is for.
+func (s *Set) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.forRequest(r).handlers.Load().(*handlers).front.ServeHTTP(w, r)
+}
@@ -0,0 +1,150 @@
+package deployer
+
+import (
+	"context"
+	"crypto/hmac"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// authGroup is a group of endpoints sharing credentials, spec returns
+// them from the settings.
+type authGroup struct {
+	name string
+	spec func(*Config) string
+}
+
+// The endpoint groups. Read endpoints show state and logs, control
+// endpoints change what is deployed.
+var (
+	readAuth    = authGroup{name: "read", spec: func(c *Config) string { return c.ReadAuth }}
+	controlAuth = authGroup{name: "control", spec: func(c *Config) string { return c.ControlAuth }}
+)
+
+// adminKey marks requests which came in through -admin-addr or
+// -admin-socket.
+type adminKey struct{}
+
+// AdminContext marks ctx, the base context of requests from the admin
+// listeners, as allowed to reach the read and control endpoints.
+func AdminContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, adminKey{}, true)
+}
+
+// credentials returns the bearer tokens and the user:password pairs g
+// accepts. open is set by "none".
+func (g authGroup) credentials() (tokens, basic []string, open bool) {
+	c := config()
+	spec := strings.TrimSpace(g.spec(c))
+	if spec == "" {
+		if c.Secret == "" {
+			return nil, nil, false
+		}
+		// The secret works as a bearer token only. Browsers would send it
+		// as a cached basic auth password to pages of other sites too, so
+		// the dashboard needs basic= credentials.
+		return []string{c.Secret}, nil, false
+	}
+
+	if spec == "none" {
+		return nil, nil, true
+	}
+
+	for _, c := range strings.Split(spec, ",") {
+		c = strings.TrimSpace(c)
+		switch {
+		case strings.HasPrefix(c, "bearer="):
+			tokens = append(tokens, strings.TrimPrefix(c, "bearer="))
+		case strings.HasPrefix(c, "basic="):
+			basic = append(basic, strings.TrimPrefix(c, "basic="))
+		}
+	}
+
+	return tokens, basic, false
+}
+
+// allows reports whether r carries credentials of g. Every credential is
+// compared in constant time, empty ones never match.
+func (g authGroup) allows(r *http.Request) bool {
+	tokens, basic, open := g.credentials()
+	if open {
+		return true
+	}
+
+	ok := false
+
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token := []byte(strings.TrimPrefix(h, "Bearer "))
+		for _, t := range tokens {
+			if t != "" && hmac.Equal(token, []byte(t)) {
+				ok = true
+			}
+		}
+	}
+
+	if user, password, set := r.BasicAuth(); set {
+		for _, b := range basic {
+			i := strings.Index(b, ":")
+			if i < 0 || b[i+1:] == "" {
+				continue
+			}
+			// An empty user accepts any user name.
+			userOK := i == 0 || hmac.Equal([]byte(user), []byte(b[:i]))
+			if hmac.Equal([]byte(password), []byte(b[i+1:])) && userOK {
+				ok = true
+			}
+		}
+	}
+
+	return ok
+}
+
+// require serves h to requests allowed by g. With AdminOnly the
+// endpoints are not served on the public listeners at all.
+func (g authGroup) require(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if config().AdminOnly && r.Context().Value(adminKey{}) == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if crossSite(r) {
+			logger(subWatcher).Warn("Cross-site request", "group", g.name, "path", r.URL.Path, "ip", r.RemoteAddr)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if !g.allows(r) {
+			logger(subWatcher).Warn("Unauthorized request", "group", g.name, "path", r.URL.Path, "ip", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Basic realm="watcher"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		h(w, r, ps)
+	}
+}
+
+// crossSite reports whether r changes state and was sent by a page of
+// another site. Browsers send the credentials they cached for the
+// dashboard along with forms posted from anywhere, so those are refused.
+// Clients other than browsers send neither header.
+func crossSite(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return false
+	}
+
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin" && site != "none"
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err != nil || !strings.EqualFold(u.Host, r.Host)
+	}
+
+	return false
+}
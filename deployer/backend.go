@@ -0,0 +1,296 @@
+package deployer
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/romanyx/watcher/builder"
+	"github.com/romanyx/watcher/proxy"
+)
+
+// backend is a running instance of the built binary together with the
+// reverse proxy pointing at it. With -replicas it leads the other instances
+// of its side, its peers, and balances requests across them.
+type backend struct {
+	proxy *httputil.ReverseProxy
+	head  string
+
+	// cmd is nil for processes adopted from an earlier watcher. halt,
+	// when set, stops an instance which is no process of the watcher in
+	// place of signalling process.
+	cmd     *exec.Cmd
+	process *os.Process
+	halt    func() error
+
+	// network and addr are where the instance listens, port is set for
+	// tcp only. base is the URL requests are proxied to, transport
+	// carries them.
+	network, addr string
+	port          int
+	base          *url.URL
+	transport     http.RoundTripper
+
+	// inflight counts proxied requests which are not finished yet,
+	// upgrades holds the ones switched to another protocol.
+	inflight int64
+	upgrades proxy.Upgrades
+
+	// retry may serve a request which failed to reach the instance
+	// by other means, it reports whether it did.
+	retry func(w http.ResponseWriter, r *http.Request, err error) bool
+
+	// stopping is set to 1 once the instance is being stopped on
+	// purpose, so its exit is not taken for a crash.
+	stopping int32
+	started  time.Time
+
+	// done is closed once the process exited, err is its exit error.
+	done chan struct{}
+	err  error
+
+	// peers are the other replicas of the side, guarded by peerMu once
+	// b serves, turn picks the next one. sick is set to 1 while the
+	// instance fails health probes.
+	peerMu sync.Mutex
+	peers  []*backend
+	turn   uint64
+	sick   int32
+
+	// requests counts the proxied requests, failures the ones answered
+	// with 5xx or which didn't reach the instance.
+	requests, failures int64
+}
+
+// newBackend returns a backend proxying to an instance which listens on
+// network and addr, "tcp" or "unix". The process is set by the caller.
+func newBackend(network, addr string) (*backend, error) {
+	t, scheme, err := config().Transport.Backend(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &backend{
+		network:   network,
+		addr:      addr,
+		transport: t,
+		done:      make(chan struct{}),
+	}
+
+	switch network {
+	case "tcp":
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, errors.Wrap(err, "split host port")
+		}
+
+		b.port, _ = strconv.Atoi(port)
+		b.base = &url.URL{Scheme: scheme, Host: addr, Path: "/"}
+	case "unix":
+		b.base = &url.URL{Scheme: scheme, Host: "unix", Path: "/"}
+	default:
+		return nil, errors.Errorf("unknown network %q", network)
+	}
+
+	b.proxy = httputil.NewSingleHostReverseProxy(b.base)
+	b.proxy.Transport = b.transport
+
+	b.proxy.ModifyResponse = func(resp *http.Response) error {
+		atomic.AddInt64(&b.requests, 1)
+		if resp.StatusCode >= 500 {
+			atomic.AddInt64(&b.failures, 1)
+		}
+		return nil
+	}
+
+	b.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		atomic.AddInt64(&b.requests, 1)
+		atomic.AddInt64(&b.failures, 1)
+
+		if b.retry != nil && b.retry(w, r, err) {
+			return
+		}
+
+		logger(subProxy).Error("Proxy failed", "sha", b.head, "addr", b.addr, "err", err)
+		config().Maintenance.ServeHTTP(w, r)
+	}
+
+	director := b.proxy.Director
+	b.proxy.Director = func(r *http.Request) {
+		config().Trusted.Forward(r)
+		director(r)
+	}
+
+	return b, nil
+}
+
+// startBackend starts cmd within limits, it listens on network and addr.
+func startBackend(cmd *exec.Cmd, limits builder.Limits, network, addr string) (*backend, error) {
+	b, err := newBackend(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := limits.Start(cmd)
+	if err != nil {
+		return nil, errors.Wrap(err, "start")
+	}
+	b.cmd = cmd
+	b.process = cmd.Process
+	b.started = time.Now()
+
+	go func() {
+		b.err = cmd.Wait()
+		release()
+		close(b.done)
+	}()
+
+	return b, nil
+}
+
+// adoptBackend takes over the running process pid, started by an earlier
+// watcher, which listens on network and addr. As it is not our child its
+// exit is noticed by polling.
+func adoptBackend(pid int, network, addr string) (*backend, error) {
+	b, err := newBackend(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, errors.Wrap(err, "find process")
+	}
+
+	if !processAlive(pid) {
+		return nil, errors.Errorf("process %d is not running", pid)
+	}
+	b.process = process
+	b.started = time.Now()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !processAlive(pid) {
+				close(b.done)
+				return
+			}
+		}
+	}()
+
+	return b, nil
+}
+
+// alive reports whether the process of b is still running.
+func (b *backend) alive() bool {
+	select {
+	case <-b.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// ServeHTTP proxies r to the backend, or to the replica next in turn.
+func (b *backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.pick().serve(w, r)
+}
+
+// serve proxies r to the instance of b.
+func (b *backend) serve(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&b.inflight, 1)
+	defer atomic.AddInt64(&b.inflight, -1)
+
+	if proxy.IsUpgrade(r) {
+		w = b.upgrades.Track(w)
+	}
+
+	b.proxy.ServeHTTP(w, r)
+}
+
+// stop stops b and its peers.
+func (b *backend) stop(grace time.Duration) error {
+	// Set before the peers are listed, so no crashed one is replaced
+	// behind stopPeers.
+	atomic.StoreInt32(&b.stopping, 1)
+
+	peersErr := make(chan error, 1)
+	go func() { peersErr <- b.stopPeers(grace) }()
+
+	if err := b.stopOne(grace); err != nil {
+		<-peersErr
+		return err
+	}
+
+	return <-peersErr
+}
+
+// stopOne waits for in-flight requests to finish, then asks the process to
+// terminate, with SIGTERM on Unix. The process is killed when it is still running
+// after grace. Upgraded connections are closed right away with
+// -upgrade-switch=close, otherwise they are drained as well.
+func (b *backend) stopOne(grace time.Duration) error {
+	atomic.StoreInt32(&b.stopping, 1)
+
+	if config().UpgradeSwitch == "close" {
+		b.upgrades.CloseAll()
+	}
+
+	deadline := time.After(grace)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+drain:
+	for atomic.LoadInt64(&b.inflight) > 0 {
+		select {
+		case <-b.done:
+			return nil
+		case <-deadline:
+			break drain
+		case <-ticker.C:
+		}
+	}
+
+	select {
+	case <-b.done:
+		return nil
+	default:
+	}
+
+	if b.halt != nil {
+		return b.halt()
+	}
+
+	if err := terminate(b.process); err != nil {
+		select {
+		case <-b.done:
+			return nil
+		default:
+		}
+		return errors.Wrap(err, "terminate")
+	}
+
+	select {
+	case <-b.done:
+		return nil
+	case <-deadline:
+	}
+
+	if err := b.process.Kill(); err != nil {
+		return errors.Wrap(err, "kill")
+	}
+	<-b.done
+
+	return nil
+}
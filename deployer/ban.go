@@ -0,0 +1,126 @@
+package deployer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// banList bans addresses which failed too often.
+type banList struct {
+	mu        sync.Mutex
+	threshold int
+	duration  time.Duration
+	failures  map[string][]time.Time
+	bans      map[string]time.Time
+}
+
+func newBanList(threshold int, duration time.Duration) *banList {
+	return &banList{
+		threshold: threshold,
+		duration:  duration,
+		failures:  make(map[string][]time.Time),
+		bans:      make(map[string]time.Time),
+	}
+}
+
+// banned reports whether ip is banned at now.
+func (b *banList) banned(ip string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	until, ok := b.bans[ip]
+	if ok && now.After(until) {
+		delete(b.bans, ip)
+		return false
+	}
+
+	return ok
+}
+
+// fail records a failure of ip at now. It bans ip and returns true once ip
+// failed threshold times within the ban duration.
+func (b *banList) fail(ip string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	recent := b.failures[ip][:0]
+	for _, t := range b.failures[ip] {
+		if now.Sub(t) < b.duration {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+
+	if b.threshold > 0 && len(recent) >= b.threshold {
+		delete(b.failures, ip)
+		b.bans[ip] = now.Add(b.duration)
+		return true
+	}
+
+	b.failures[ip] = recent
+
+	return false
+}
+
+// ban is a banned address and when the ban ends.
+type ban struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+// list returns the bans in effect at now.
+func (b *banList) list(now time.Time) []ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var bans []ban
+	for ip, until := range b.bans {
+		if now.Before(until) {
+			bans = append(bans, ban{IP: ip, Until: until})
+		}
+	}
+
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+
+	return bans
+}
+
+// rateLimiter is a token bucket allowing rate requests per minute with
+// bursts of the same size.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{rate: float64(perMinute), tokens: float64(perMinute)}
+}
+
+// allow takes a token at now and reports whether there was one. A limiter
+// with zero rate allows everything.
+func (l *rateLimiter) allow(now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Minutes() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+
+	return true
+}
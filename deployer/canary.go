@@ -0,0 +1,259 @@
+package deployer
+
+import (
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+var errNoCanary = errors.New("no canary running")
+
+// candidate is a started new build which does not receive all traffic yet:
+// a canary getting a share of it or a build staged for approval.
+type candidate struct {
+	backend    *backend
+	deployment *deployment
+	side       int
+}
+
+func canaryEnabled() bool {
+	return config().Canary > 0 || config().CanaryHeader != "" || config().CanaryCookie != ""
+}
+
+// routing is what requests are routed by, along with the deployment state
+// /_status reports. It is never changed, reroute replaces it as a whole so
+// readers see either all of a switch or none of it.
+type routing struct {
+	backend *backend
+	canary  *candidate
+
+	last, dir, failure   string
+	side                 int
+	history              []*deployment
+	rolledBack           string
+	staged               *staged
+	crashes, consecutive int
+}
+
+// route returns the routing requests are served by now. It doesn't take
+// m.mu, which is held for whole builds.
+func (m *Manager) route() *routing {
+	rt, _ := m.routing.Load().(*routing)
+	if rt == nil {
+		return &routing{}
+	}
+
+	return rt
+}
+
+// reroute publishes the backend and canary of m to requests. It
+// is the switch point: the caller must hold m.mu and call it whenever one
+// of them changed.
+func (m *Manager) reroute() {
+	m.routing.Store(&routing{
+		backend:     m.backend,
+		canary:      m.canary,
+		last:        m.last,
+		dir:         m.dir,
+		failure:     m.failure,
+		side:        m.side,
+		history:     append([]*deployment(nil), m.history...),
+		rolledBack:  m.rolledBack,
+		staged:      m.staged,
+		crashes:     m.crashes,
+		consecutive: m.consecutive,
+	})
+}
+
+// unlock publishes the state of m, which only changes under m.mu, and
+// releases m.mu.
+func (m *Manager) unlock() {
+	m.reroute()
+	m.mu.Unlock()
+}
+
+// pick returns the backend which should serve r. With -sticky=cookie the
+// choice is remembered in a cookie set on w, which may be nil.
+func (m *Manager) pick(w http.ResponseWriter, r *http.Request) *backend {
+	rt := m.route()
+	if b := rt.sticky(r); b != nil {
+		return b
+	}
+
+	b := rt.choose(r)
+	rt.stick(w, b)
+
+	return b
+}
+
+// choose returns the serving backend or the canary for r. A canary which
+// exited is passed over until it is dropped.
+func (rt *routing) choose(r *http.Request) *backend {
+	c := rt.canary
+	if c == nil || !c.backend.alive() {
+		return rt.backend
+	}
+
+	if config().CanaryHeader != "" {
+		name, value := splitMatch(config().CanaryHeader)
+		if v := r.Header.Get(name); v != "" && (value == "" || v == value) {
+			return c.backend
+		}
+	}
+
+	if config().CanaryCookie != "" {
+		name, value := splitMatch(config().CanaryCookie)
+		if ck, err := r.Cookie(name); err == nil && (value == "" || ck.Value == value) {
+			return c.backend
+		}
+	}
+
+	n := rand.Intn(100)
+	if config().Sticky == "ip" {
+		n = percentile(r)
+	}
+
+	if n < config().Canary {
+		return c.backend
+	}
+
+	return rt.backend
+}
+
+// splitMatch splits a "name=value" match rule, value may be empty to match
+// any value.
+func splitMatch(rule string) (string, string) {
+	i := strings.Index(rule, "=")
+	if i < 0 {
+		return rule, ""
+	}
+
+	return rule[:i], rule[i+1:]
+}
+
+// promoteCanary sends all traffic to the canary.
+func (m *Manager) promoteCanary() (string, error) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	c := m.canary
+	if c == nil {
+		return "", errNoCanary
+	}
+	// Cleared in the reroute of the switch, so the users of the canary
+	// stay on it.
+	m.canary = nil
+
+	m.promote(c)
+	m.logger(subSupervisor).Info("Canary promoted")
+
+	return m.last, nil
+}
+
+// abortCanary stops the canary and removes its build.
+func (m *Manager) abortCanary() (string, error) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	c := m.canary
+	if c == nil {
+		return "", errNoCanary
+	}
+	m.canary = nil
+	m.reroute()
+
+	m.drop(c)
+	m.failure = "canary of " + c.deployment.head + " aborted"
+
+	return c.deployment.head, nil
+}
+
+// startCanary lets c serve its share of the traffic. The caller must hold
+// m.mu.
+func (m *Manager) startCanary(c *candidate) {
+	m.canary = c
+	m.reroute()
+
+	go m.superviseCanary(c)
+}
+
+// superviseCanary drops c when its instance exits while it is the canary,
+// so its users are sent back to the serving build.
+func (m *Manager) superviseCanary(c *candidate) {
+	<-c.backend.done
+
+	if atomic.LoadInt32(&c.backend.stopping) == 1 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.canary != c {
+		return
+	}
+	m.canary = nil
+	m.reroute()
+
+	c.logger(subSupervisor).Warn("Canary exited", "err", c.backend.err)
+	m.drop(c)
+	m.failure = "canary of " + c.deployment.head + " exited"
+
+	ev := deployEvent{kind: eventFailed, head: c.deployment.head, err: m.failure}
+	if c.backend.err != nil {
+		ev.err = c.backend.err.Error()
+	}
+	m.notify(ev)
+}
+
+// promote sends all traffic to c. The caller must hold m.mu.
+func (m *Manager) promote(c *candidate) {
+	if err := m.switchTo(c.backend); err != nil {
+		c.logger(subSupervisor).Error("Switch failed", "err", err)
+	}
+
+	m.notify(deployEvent{kind: eventSwitched, head: c.deployment.head, from: m.last})
+	m.remember(c.deployment)
+
+	m.side = c.side
+	m.dir = c.deployment.dir
+	m.last = c.deployment.head
+	m.failure = ""
+	m.consecutive = 0
+	m.saveState()
+
+	go m.guard(c.backend)
+}
+
+// drop stops c and removes its build.
+func (m *Manager) drop(c *candidate) {
+	if err := c.backend.stop(config().DrainGrace); err != nil {
+		c.logger(subSupervisor).Error("Stop candidate failed", "err", err)
+	}
+
+	if err := os.RemoveAll(c.deployment.dir); err != nil {
+		c.logger(subSupervisor).Error("Remove candidate directory failed", "err", err)
+	}
+
+	c.logger(subSupervisor).Info("Candidate dropped")
+}
+
+// dropCandidates stops a running canary and a staged build. The caller
+// must hold m.mu.
+func (m *Manager) dropCandidates() {
+	if c := m.canary; c != nil {
+		m.canary = nil
+		m.reroute()
+		m.drop(c)
+	}
+
+	if m.staged != nil {
+		m.staged.timer.Stop()
+		m.drop(m.staged.candidate)
+		m.staged = nil
+	}
+}
@@ -0,0 +1,167 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errCIFailed is returned by waitForCI when a required check did not pass.
+var errCIFailed = errors.New("ci failed")
+
+// waitForCI polls GitHub statuses and check runs of sha until every
+// required check passed, one of them failed or the CI timeout expired.
+func waitForCI(ctx context.Context, repo, sha string) error {
+	ctx, cancel := context.WithTimeout(ctx, config().CITimeout)
+	defer cancel()
+
+	for {
+		ok, err := ciPassed(ctx, repo, sha)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return errors.Errorf("ci of %s not finished after %s", sha, config().CITimeout)
+			}
+			return ctx.Err()
+		case <-time.After(config().CIInterval):
+		}
+	}
+}
+
+// ciPassed reports whether all checks of sha are green. It returns
+// errCIFailed as soon as one of them failed.
+func ciPassed(ctx context.Context, repo, sha string) (bool, error) {
+	// result holds the state of every check by name: "success", "pending"
+	// or "failure".
+	result := make(map[string]string)
+
+	// Both listings are paged, 100 entries per page at most.
+	path := fmt.Sprintf("/repos/%s/commits/%s/status?per_page=100", repo, sha)
+	for path != "" {
+		status := struct {
+			Statuses []struct {
+				Context string `json:"context"`
+				State   string `json:"state"`
+			} `json:"statuses"`
+		}{}
+
+		var err error
+		if path, err = githubPage(ctx, path, &status); err != nil {
+			return false, errors.Wrap(err, "get commit status")
+		}
+
+		for _, s := range status.Statuses {
+			switch s.State {
+			case "success":
+				result[s.Context] = "success"
+			case "pending":
+				result[s.Context] = "pending"
+			default:
+				result[s.Context] = "failure"
+			}
+		}
+	}
+
+	path = fmt.Sprintf("/repos/%s/commits/%s/check-runs?per_page=100", repo, sha)
+	for path != "" {
+		checks := struct {
+			CheckRuns []struct {
+				Name       string `json:"name"`
+				Status     string `json:"status"`
+				Conclusion string `json:"conclusion"`
+			} `json:"check_runs"`
+		}{}
+
+		var err error
+		if path, err = githubPage(ctx, path, &checks); err != nil {
+			return false, errors.Wrap(err, "get check runs")
+		}
+
+		for _, c := range checks.CheckRuns {
+			switch {
+			case c.Status != "completed":
+				result[c.Name] = "pending"
+			case c.Conclusion == "success", c.Conclusion == "neutral", c.Conclusion == "skipped":
+				result[c.Name] = "success"
+			default:
+				result[c.Name] = "failure"
+			}
+		}
+	}
+
+	required := requiredChecks()
+	if len(required) == 0 {
+		for name := range result {
+			required = append(required, name)
+		}
+	}
+
+	if len(required) == 0 {
+		// Nothing reported yet, CI may not have picked the commit up.
+		return false, nil
+	}
+
+	passed := true
+	for _, name := range required {
+		switch result[name] {
+		case "failure":
+			return false, errors.Wrapf(errCIFailed, "check %q of %s", name, sha)
+		case "success":
+		default:
+			passed = false
+		}
+	}
+
+	return passed, nil
+}
+
+func requiredChecks() []string {
+	var names []string
+	for _, name := range strings.Split(config().CIChecks, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// resolveRef returns the commit hash ref (a branch, tag or hash) points to.
+func resolveRef(ctx context.Context, repo, ref string) (string, error) {
+	commit := struct {
+		Sha string `json:"sha"`
+	}{}
+
+	if err := githubGet(ctx, fmt.Sprintf("/repos/%s/commits/%s", repo, url.PathEscape(ref)), &commit); err != nil {
+		return "", errors.Wrapf(err, "get commit of %s", ref)
+	}
+
+	return commit.Sha, nil
+}
+
+// commitMessage returns the first line of the message of commit sha.
+func commitMessage(ctx context.Context, repo, sha string) (string, error) {
+	commit := struct {
+		Commit struct {
+			Message string `json:"message"`
+		} `json:"commit"`
+	}{}
+
+	if err := githubGet(ctx, fmt.Sprintf("/repos/%s/commits/%s", repo, url.PathEscape(sha)), &commit); err != nil {
+		return "", errors.Wrapf(err, "get commit of %s", sha)
+	}
+
+	return strings.SplitN(commit.Commit.Message, "\n", 2)[0], nil
+}
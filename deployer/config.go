@@ -0,0 +1,189 @@
+package deployer
+
+import (
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/romanyx/watcher/proxy"
+	"github.com/romanyx/watcher/vcs"
+)
+
+// Config holds the settings of the apps of a watcher, named after the
+// flags they come from. A Config must not be changed once it is in use,
+// a reload swaps in a new one with Set.Configure.
+type Config struct {
+	// Secret checks webhook signatures. ReadAuth and ControlAuth are the
+	// credentials of the read and control endpoints, see -read-auth.
+	// With AdminOnly those endpoints are only served to requests carrying
+	// AdminContext.
+	Secret, ReadAuth, ControlAuth string
+	AdminOnly                     bool
+
+	BanThreshold   int
+	BanDuration    time.Duration
+	PushRate       int
+	WebhookRepos   string
+	WebhookMaxBody int64
+
+	RequireSigned           bool
+	GPGHome, AllowedSigners string
+
+	GithubToken   string
+	GithubRetries int
+	WaitCI        bool
+	CIChecks      string
+	CITimeout     time.Duration
+	CIInterval    time.Duration
+
+	// Builds.
+	VCS                                           string
+	Submodules                                    bool
+	Builder, MakeTarget, DockerImage, BuildScript string
+	Install                                       string
+	BuildTimeout                                  time.Duration
+	BuildLimits, RunLimits, Cgroup                string
+	MinFree, Retain                               int
+	DockerPush, PushOnly                          bool
+	RegistryUser, RegistryPassword                string
+	WatchDir                                      string
+	WatchDebounce                                 time.Duration
+	LogsDir                                       string
+	LogsRetain                                    int
+
+	// Env is the environment of builds and instances. Build is reported
+	// by /_version and /_status.
+	Env   []string
+	Build BuildInfo
+
+	// Targets other than local instances.
+	SSHHosts, SSHDir, SSHStart, SSHStop, SSHIdentity string
+	SSHPort                                          int
+	K8sDeployment, K8sNamespace, K8sContainer        string
+	K8sImage, Kubeconfig                             string
+	K8sTimeout                                       time.Duration
+
+	// Lease coordinates redundant watchers.
+	Lease, InstanceID string
+	LeaseTTL          time.Duration
+
+	// Instances.
+	Run, RunDir, PortEnv              string
+	Socket                            bool
+	Replicas                          int
+	HealthPath                        string
+	HealthTimeout, HealthInterval     time.Duration
+	HealthThreshold                   int
+	Transport                         proxy.Transport
+	UpgradeSwitch                     string
+	DrainGrace                        time.Duration
+	MaxRestarts                       int
+	RestartBackoff, MaxRestartBackoff time.Duration
+
+	RollbackWindow                         time.Duration
+	RollbackErrorRate, RollbackMinRequests int
+	RollbackHealthFailures                 int
+
+	// Rollout.
+	Canary                             int
+	CanaryHeader, CanaryCookie, Sticky string
+	Approval, DryRun                   bool
+	ApproveTimeout, Poll               time.Duration
+	DeployWindow                       string
+	RedeploySchedule, RestartSchedule  string
+
+	// Notifications.
+	EventHooks, Plugins              []string
+	EventHookSecret                  string
+	PluginTimeout                    time.Duration
+	SlackWebhook, DiscordWebhook     string
+	TelegramToken, TelegramChat      string
+	PublicURL                        string
+	SMTPAddr, SMTPUser, SMTPPassword string
+	MailFrom, MailTo                 string
+
+	// Request path.
+	Routes, Allow                   []string
+	RequestHeaders, ResponseHeaders []string
+	CacheControl                    string
+	Gzip, TraceRequests             bool
+	MetricsPath                     string
+	AllowRefresh, Hold              time.Duration
+	Trusted                         proxy.Trusted
+	Maintenance                     proxy.Maintenance
+	AccessLog                       *proxy.AccessLog
+}
+
+// current holds the *Config in use, shared by all apps.
+var current atomic.Value
+
+// config returns the settings in use.
+func config() *Config {
+	return current.Load().(*Config)
+}
+
+// Validate checks the settings which are not checked where they are used.
+func (c *Config) Validate() error {
+	if c.DeployWindow != "" {
+		if _, err := parseCron(c.DeployWindow); err != nil {
+			return errors.Wrap(err, "invalid -deploy-window")
+		}
+	}
+
+	for _, pattern := range strings.Split(c.WebhookRepos, ",") {
+		if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+			return errors.Wrapf(err, "invalid -webhook-repos pattern %q", pattern)
+		}
+	}
+
+	for name, expr := range map[string]string{"redeploy-schedule": c.RedeploySchedule, "restart-schedule": c.RestartSchedule} {
+		if expr == "" {
+			continue
+		}
+		if _, err := parseCron(expr); err != nil {
+			return errors.Wrap(err, "invalid -"+name)
+		}
+	}
+
+	for name, spec := range map[string]string{"build-limits": c.BuildLimits, "run-limits": c.RunLimits} {
+		if _, err := c.parseLimits(spec); err != nil {
+			return errors.Wrap(err, "invalid -"+name)
+		}
+	}
+
+	if _, _, err := c.Transport.Backend("tcp", ""); err != nil {
+		return errors.Wrap(err, "invalid backend transport")
+	}
+
+	if c.Sticky != "" && c.Sticky != "cookie" && c.Sticky != "ip" {
+		return errors.New("-sticky must be cookie or ip")
+	}
+
+	if c.UpgradeSwitch != "drain" && c.UpgradeSwitch != "close" {
+		return errors.New("-upgrade-switch must be drain or close")
+	}
+
+	if _, err := c.newBuilder(); err != nil {
+		return errors.Wrap(err, "invalid -builder")
+	}
+
+	if _, err := vcs.New(c.VCS); err != nil {
+		return errors.Wrap(err, "invalid -vcs")
+	}
+
+	if c.VCS == "go-git" && c.RequireSigned {
+		return errors.New("-require-signed needs -vcs=git")
+	}
+
+	if c.PushOnly && c.Builder != "docker" {
+		return errors.New("-push-only needs -builder=docker")
+	}
+
+	if c.WatchDir != "" && c.RequireSigned {
+		return errors.New("-require-signed can't be combined with -watch-dir")
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package deployer
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// schedule is a parsed cron expression of the five standard fields:
+// minute, hour, day of month, month and day of week.
+type schedule struct {
+	fields [5]map[int]bool
+}
+
+var cronBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+
+// cronDescriptors are the shorthands parseCron accepts for whole
+// expressions.
+var cronDescriptors = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// parseCron parses expr, fields support "*", numbers, ranges "a-b", steps
+// "*/n" or "a-b/n" and comma separated lists of those. The descriptors
+// @hourly, @daily, @midnight, @weekly and @monthly stand for whole
+// expressions.
+func parseCron(expr string) (*schedule, error) {
+	if d, ok := cronDescriptors[strings.TrimSpace(expr)]; ok {
+		expr = d
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, errors.Errorf("cron %q: want 5 fields, got %d", expr, len(parts))
+	}
+
+	s := &schedule{}
+	for i, part := range parts {
+		f, err := parseCronField(part, cronBounds[i][0], cronBounds[i][1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "cron %q", expr)
+		}
+		s.fields[i] = f
+	}
+
+	return s, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n < 1 {
+				return nil, errors.Errorf("invalid step in %q", item)
+			}
+			step, item = n, item[:i]
+		}
+
+		lo, hi := min, max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, errors.Errorf("invalid value %q", item)
+			}
+
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, errors.Errorf("invalid value %q", item)
+				}
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return nil, errors.Errorf("%q out of range %d-%d", item, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// match reports whether the minute of t is in the schedule.
+func (s *schedule) match(t time.Time) bool {
+	return s.fields[0][t.Minute()] &&
+		s.fields[1][t.Hour()] &&
+		s.fields[2][t.Day()] &&
+		s.fields[3][int(t.Month())] &&
+		s.fields[4][int(t.Weekday())]
+}
@@ -0,0 +1,490 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/romanyx/watcher/builder"
+	"github.com/romanyx/watcher/vcs"
+)
+
+// changeSide builds and deploys the head of a, recording the stages and
+// the result in a.
+func (m *Manager) changeSide(ctx context.Context, a *attempt) {
+	head := a.Head
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	// Canaries and staged builds run on the other side, they have to
+	// make room. Dry runs leave everything running as it is.
+	if !a.DryRun {
+		m.dropCandidates()
+	}
+
+	nSide := m.otherSide()
+	l := logger(subBuilder).With("sha", head, "side", nSide, "dry_run", a.DryRun)
+
+	dir := filepath.Join(m.buildRoot(), fmt.Sprintf("%s-%d", head, time.Now().Unix()))
+
+	m.live.begin(head)
+	defer m.live.end()
+
+	deployed, cancelled := false, false
+
+	// The end of the build output goes with a failed attempt.
+	tail := newTailBuffer(outputTail)
+
+	if a.DryRun {
+		// The failure of a dry run is reported in its attempt only.
+		failure := m.failure
+		m.failure = ""
+		defer func() {
+			os.RemoveAll(dir)
+
+			switch {
+			case deployed:
+				a.Result = resultSuccess
+			case cancelled:
+				a.Result = resultCancelled
+			default:
+				a.Result, a.Error = resultFailure, m.failure
+				a.Output = tail.String()
+			}
+			m.failure = failure
+		}()
+	} else {
+		m.set.notify("STATUS=Building " + head)
+		m.phase.set(phaseBuilding, head)
+		m.notify(deployEvent{kind: eventBuilding, head: head, trigger: a.Trigger})
+	}
+
+	defer func() {
+		if a.DryRun {
+			return
+		}
+
+		if !deployed {
+			os.RemoveAll(dir)
+		}
+
+		if !cancelled {
+			m.metrics.deploy(deployed)
+		}
+
+		switch {
+		case deployed:
+			a.Result = resultSuccess
+			m.phase.set(phaseIdle, head)
+		case cancelled:
+			a.Result = resultCancelled
+			m.phase.set(phaseIdle, "")
+		default:
+			a.Result, a.Error = resultFailure, m.failure
+			a.Output = tail.String()
+			m.phase.set(phaseFailed, head)
+		}
+
+		if m.failure != "" {
+			m.set.notify("STATUS=Deploy failed: " + m.failure)
+		} else {
+			m.set.notify("STATUS=Serving " + m.last)
+		}
+	}()
+
+	// A full disk fails the attempt up front, so it is reported like any
+	// failed deploy instead of breaking a later step.
+	if err := checkFreeSpace(os.TempDir()); err != nil {
+		m.failure = err.Error()
+		l.Error("Not enough disk space to build", "err", err)
+		return
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		m.failure = errors.Wrap(err, "create build directory").Error()
+		l.Error("Temp dir creation failed", "err", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config().BuildTimeout)
+	defer cancel()
+
+	m.pruneLogs()
+
+	buildLog, err := m.openLog(head, "build")
+	if err != nil {
+		m.failure = err.Error()
+		l.Error("Open build log failed", "err", err)
+		return
+	}
+	defer buildLog.Close()
+	buildOut := io.MultiWriter(buildLog, m.live.writer(head), tail)
+
+	// stage runs one stage of the build, it reports whether the build
+	// goes on.
+	stage := func(name string, run func(ctx context.Context) error) bool {
+		start := time.Now()
+		stageCtx, sp := startSpan(ctx, name)
+		err := run(stageCtx)
+		sp.finish(err)
+		a.stage(name, start)
+		if err == nil {
+			return true
+		}
+
+		switch ctx.Err() {
+		case context.Canceled:
+			l.Info("Build cancelled", "cause", context.Cause(ctx))
+			cancelled = true
+			return false
+		case context.DeadlineExceeded:
+			err = errors.Errorf("%s: timed out after %s", name, config().BuildTimeout)
+		}
+
+		m.failure = err.Error()
+		l.Error("Build failed", "err", err)
+		return false
+	}
+
+	repo, err := newVCS()
+	if err != nil {
+		m.failure = err.Error()
+		l.Error("Build failed", "err", err)
+		return
+	}
+
+	started := time.Now()
+
+	if !a.DryRun {
+		m.notify(deployEvent{kind: eventCloning, head: head, trigger: a.Trigger})
+	}
+
+	checkout := func(ctx context.Context) error {
+		return repo.Checkout(ctx, dir, vcs.Options{
+			URL:            fmt.Sprintf("https://github.com/%v", m.repo),
+			Head:           head,
+			Submodules:     config().Submodules,
+			VerifySigned:   config().RequireSigned,
+			AllowedSigners: config().AllowedSigners,
+			Env:            stepEnv(),
+			Out:            buildOut,
+		})
+	}
+	if !stage("checkout", checkout) {
+		return
+	}
+
+	bld, err := config().newBuilder()
+	if err != nil {
+		m.failure = err.Error()
+		l.Error("Build failed", "err", err)
+		return
+	}
+
+	limits, err := config().parseLimits(config().BuildLimits)
+	if err != nil {
+		m.failure = err.Error()
+		l.Error("Build failed", "err", errors.Wrap(err, "invalid -build-limits"))
+		return
+	}
+
+	opts := builder.Options{Binary: m.binn, Head: head}
+	env := append(stepEnv(), opts.Env()...)
+
+	for _, step := range bld.Steps(opts) {
+		build := func(ctx context.Context) error {
+			return builder.RunLimited(ctx, dir, env, buildOut, limits, step)
+		}
+		if !stage(step.Name(), build) {
+			return
+		}
+	}
+
+	m.metrics.build(time.Since(started))
+
+	if !a.DryRun {
+		m.notify(deployEvent{kind: eventBuilt, head: head, trigger: a.Trigger, duration: time.Since(started)})
+	}
+
+	d := &deployment{head: head, dir: dir, built: time.Now()}
+
+	if pushing() && !a.DryRun {
+		image := m.dockerRepo() + ":" + head
+
+		if config().RegistryUser != "" {
+			login := func(ctx context.Context) error {
+				return dockerLogin(ctx, image, buildOut)
+			}
+			if !stage("docker login", login) {
+				return
+			}
+		}
+
+		push := func(ctx context.Context) error {
+			if err := builder.Run(ctx, dir, stepEnv(), buildOut, builder.Step{"docker", "push", image}); err != nil {
+				return err
+			}
+
+			digest, err := imageDigest(ctx, image)
+			d.image, a.Image = digest, digest
+			return err
+		}
+		if !stage("docker push", push) {
+			return
+		}
+	}
+
+	if config().PushOnly {
+		deployed = true
+
+		if a.DryRun {
+			l.Info("Dry run passed, nothing was pushed")
+			return
+		}
+
+		m.remember(d)
+
+		m.dir = dir
+		m.last = head
+		m.failure = ""
+		m.saveState()
+
+		l.Info("Image pushed", "image", d.image)
+		return
+	}
+
+	// Hosts are updated one after another, a failing one stops the
+	// rollout and leaves the rest on their build.
+	if !a.DryRun {
+		for _, host := range remoteHosts() {
+			remote := func(ctx context.Context) error {
+				return m.deployRemote(ctx, host, d, buildOut)
+			}
+			if !stage("deploy "+host, remote) {
+				return
+			}
+		}
+
+		m.set.notify("STATUS=Starting " + head)
+	}
+
+	if kubeEnabled() {
+		image, err := m.kubeImage(head)
+		if err != nil {
+			m.failure = err.Error()
+			l.Error("Build failed", "err", err)
+			return
+		}
+
+		if err := m.consult(ctx, pointPreSwitch, a); err != nil {
+			m.failure = err.Error()
+			l.Warn("Switch vetoed", "err", err)
+			return
+		}
+
+		for _, step := range kubeSteps(image, a.DryRun) {
+			rollout := func(ctx context.Context) error {
+				return builder.Run(ctx, dir, stepEnv(), buildOut, step)
+			}
+			if !stage(step.Name(), rollout) {
+				return
+			}
+		}
+
+		deployed = true
+
+		if a.DryRun {
+			l.Info("Dry run passed, the deployment stays on the current image")
+			return
+		}
+
+		m.notify(deployEvent{kind: eventSwitched, head: head, from: m.last, trigger: a.Trigger})
+
+		m.remember(d)
+
+		m.dir = dir
+		m.last = head
+		m.failure = ""
+		m.saveState()
+
+		l.Info("Rolled out", "deployment", config().K8sDeployment, "image", image)
+		return
+	}
+
+	launchStart := time.Now()
+	launchCtx, sp := startSpan(ctx, "launch")
+	b, err := m.launch(launchCtx, nSide, d)
+	sp.finish(err)
+	a.stage("launch", launchStart)
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			l.Info("Start cancelled", "cause", context.Cause(ctx))
+			cancelled = true
+			return
+		}
+		m.failure = err.Error()
+		l.Error("Launch failed", "err", err)
+		return
+	}
+
+	if !a.DryRun {
+		m.notify(deployEvent{kind: eventHealthy, head: head, trigger: a.Trigger})
+	}
+
+	if err := m.consult(ctx, pointPreSwitch, a); err != nil {
+		if err := b.stop(config().DrainGrace); err != nil {
+			l.Error("Stop vetoed instance failed", "err", err)
+		}
+		m.failure = err.Error()
+		l.Warn("Switch vetoed", "err", err)
+		return
+	}
+
+	deployed = true
+
+	if a.DryRun {
+		if err := b.stop(config().DrainGrace); err != nil {
+			l.Error("Stop dry run instance failed", "err", err)
+		}
+		l.Info("Dry run passed, traffic stays on the current build")
+		return
+	}
+
+	if config().Approval {
+		m.stage(&candidate{backend: b, deployment: d, side: nSide})
+		m.failure = ""
+		return
+	}
+
+	if canaryEnabled() {
+		m.startCanary(&candidate{backend: b, deployment: d, side: nSide})
+		m.failure = ""
+		l.Info("Canary started")
+		return
+	}
+
+	m.phase.set(phaseSwitching, head)
+	switchStart := time.Now()
+	_, sp = startSpan(ctx, "switch")
+	err = m.switchTo(b)
+	if err != nil {
+		l.Error("Switch failed", "err", err)
+	}
+	sp.finish(err)
+	a.stage("switch", switchStart)
+
+	m.notify(deployEvent{kind: eventSwitched, head: head, from: m.last, trigger: a.Trigger})
+
+	m.remember(d)
+
+	m.side = nSide
+	m.dir = dir
+	m.last = head
+	m.failure = ""
+	m.consecutive = 0
+	m.saveState()
+
+	go m.guard(b)
+
+	l.Info("Project was rebuilt")
+}
+
+func (m *Manager) firstBuild(ctx context.Context) error {
+	var (
+		current string
+		err     error
+	)
+	if config().WatchDir != "" {
+		current, err = treeHead(config().WatchDir)
+	} else {
+		current, err = m.getCurrent(ctx)
+	}
+	if err != nil && m.last != "" {
+		// Serve the head restored from -state, pushes and -poll catch
+		// up once GitHub answers again.
+		logger(subWatcher).Warn("Get current failed, keeping the recorded head", "app", m.name, "sha", m.last, "err", err)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "get current")
+	}
+
+	ok := m.last == current
+	m.last = current
+
+	if ok {
+		// Followers wait for the leader to deploy the head it serves.
+		m.recordTurn(current, resultSuccess)
+		return nil
+	}
+
+	deploying.Add(1)
+	defer deploying.Done()
+
+	m.deploy(ctx, current, triggerStartup, false, config().DryRun)
+
+	return nil
+}
+
+// getCurrent returns the head of the branch of m.
+func (m *Manager) getCurrent(ctx context.Context) (string, error) {
+	commit := struct {
+		Sha string `json:"sha"`
+	}{}
+
+	if err := githubGet(ctx, fmt.Sprintf("/repos/%v/commits/%v", m.repo, url.PathEscape(m.branch)), &commit); err != nil {
+		return "", err
+	}
+
+	return commit.Sha, nil
+}
+
+// newVCS returns the VCS chosen with -vcs, or one copying -watch-dir.
+func newVCS() (vcs.VCS, error) {
+	if config().WatchDir != "" {
+		return vcs.Local{Root: config().WatchDir}, nil
+	}
+
+	return vcs.New(config().VCS)
+}
+
+// newBuilder returns the builder chosen with -builder.
+func (c *Config) newBuilder() (builder.Builder, error) {
+	return builder.New(c.Builder, builder.Config{
+		MakeTarget:  c.MakeTarget,
+		DockerImage: c.DockerImage,
+		Script:      c.BuildScript,
+		Install:     c.Install,
+	})
+}
+
+// parseLimits parses the resource limits spec of -build-limits or
+// -run-limits.
+func (c *Config) parseLimits(spec string) (builder.Limits, error) {
+	l, err := builder.ParseLimits(spec)
+	l.Cgroup = c.Cgroup
+
+	return l, err
+}
+
+// childEnv returns a copy of the environment of builds and instances,
+// which the caller may append to. Config.Env is shared by all apps.
+func childEnv() []string {
+	return append([]string(nil), config().Env...)
+}
+
+// stepEnv returns the environment of build steps.
+func stepEnv() []string {
+	env := childEnv()
+	if config().GPGHome != "" {
+		env = append(env, "GNUPGHOME="+config().GPGHome)
+	}
+
+	return env
+}
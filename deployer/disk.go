@@ -0,0 +1,71 @@
+package deployer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// buildRoot returns the directory the builds of m are made in.
+func (m *Manager) buildRoot() string {
+	return filepath.Join(os.TempDir(), m.binn)
+}
+
+// sweepBuilds removes the build directories of m left behind by earlier
+// runs, keeping the ones of the restored history.
+func (m *Manager) sweepBuilds() {
+	root := m.buildRoot()
+
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			m.logger(subBuilder).Warn("Sweep build directories failed", "err", err)
+		}
+		return
+	}
+
+	keep := map[string]bool{m.dir: true}
+	for _, d := range m.history {
+		keep[d.dir] = true
+	}
+
+	for _, fi := range entries {
+		// Hidden entries like the app lock are not builds.
+		if strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+
+		dir := filepath.Join(root, fi.Name())
+		if keep[dir] {
+			continue
+		}
+
+		if err := os.RemoveAll(dir); err != nil {
+			m.logger(subBuilder).Warn("Remove stale build failed", "dir", dir, "err", err)
+			continue
+		}
+		m.logger(subBuilder).Info("Removed stale build", "dir", dir)
+	}
+}
+
+// checkFreeSpace fails when the file system of dir has less than
+// -min-free megabytes available.
+func checkFreeSpace(dir string) error {
+	if config().MinFree <= 0 {
+		return nil
+	}
+
+	free, err := freeSpace(dir)
+	if err != nil {
+		return errors.Wrapf(err, "free space of %s", dir)
+	}
+
+	if free < uint64(config().MinFree)<<20 {
+		return errors.Errorf("only %d MB free in %s, -min-free is %d MB", free>>20, dir, config().MinFree)
+	}
+
+	return nil
+}
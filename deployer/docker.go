@@ -0,0 +1,80 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pushing reports whether images built with -builder=docker are pushed.
+func pushing() bool {
+	return config().Builder == "docker" && (config().DockerPush || config().PushOnly || kubeEnabled())
+}
+
+// runsLocally reports whether builds are started by the watcher, not by a
+// cluster or the consumers of pushed images.
+func runsLocally() bool {
+	return !kubeEnabled() && !config().PushOnly
+}
+
+// dockerRepo returns the image -builder=docker builds, tagged with the
+// commit.
+func (m *Manager) dockerRepo() string {
+	if config().DockerImage != "" {
+		return config().DockerImage
+	}
+
+	return m.binn
+}
+
+// registryHost returns the registry of image, empty for Docker Hub.
+func registryHost(image string) string {
+	i := strings.Index(image, "/")
+	if i < 0 {
+		return ""
+	}
+
+	host := image[:i]
+	if strings.ContainsAny(host, ".:") || host == "localhost" {
+		return host
+	}
+
+	return ""
+}
+
+// dockerLogin logs in to the registry of image as -registry-user, the
+// password is passed on stdin to keep it out of ps.
+func dockerLogin(ctx context.Context, image string, out io.Writer) error {
+	args := []string{"login", "--username", config().RegistryUser, "--password-stdin"}
+	if host := registryHost(image); host != "" {
+		args = append(args, host)
+	}
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdin = strings.NewReader(config().RegistryPassword)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.Env = stepEnv()
+
+	return errors.Wrap(cmd.Run(), "docker login")
+}
+
+// imageDigest returns the repository digest of the pushed image, like
+// registry/app@sha256:...
+func imageDigest(ctx context.Context, image string) (string, error) {
+	var out bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "docker", "image", "inspect", "--format={{index .RepoDigests 0}}", image)
+	cmd.Stdout = &out
+	cmd.Env = stepEnv()
+
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrap(err, "docker image inspect")
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
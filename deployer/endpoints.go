@@ -0,0 +1,372 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/romanyx/watcher/webhook"
+)
+
+// routes registers the webhook and control endpoints of m and sets its
+// proxied app.
+func (m *Manager) routes() {
+	m.router.POST("/_github_push", httprouter.Handle(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if config().Secret == "" {
+			// Only -watch-dir runs without a secret, it takes no pushes.
+			http.NotFound(w, r)
+			return
+		}
+
+		ip := config().Trusted.ClientIP(r)
+		if m.bans.banned(ip, time.Now()) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if !m.pushLimit.allow(time.Now()) {
+			logger(subWebhook).Warn("Push rate limited", "ip", ip)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		h := &webhook.Handler{
+			Secret:  config().Secret,
+			OnPush:  m.onPush,
+			OnError: m.onWebhookError,
+			MaxBody: config().WebhookMaxBody,
+		}
+		h.ServeHTTP(w, r)
+	}))
+
+	m.router.GET("/_admin", readAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		m.serveAdmin(w, r)
+	}))
+
+	m.router.GET("/_status", readAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		s := m.status()
+		if wantsText(r) {
+			writeStatusText(w, s)
+			return
+		}
+
+		writeStatusJSON(w, s)
+	}))
+
+	if config().MetricsPath != "" {
+		m.router.GET(config().MetricsPath, readAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			m.metrics.write(w, m.route().side)
+		}))
+	}
+
+	m.router.GET("/_version", readAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(config().Build)
+	}))
+
+	m.router.GET("/_healthz", httprouter.Handle(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		fmt.Fprint(w, "ok")
+	}))
+
+	m.router.GET("/_readyz", httprouter.Handle(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if !m.ready() {
+			http.Error(w, "no backend", http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprint(w, "ok")
+	}))
+
+	m.router.GET("/_deployments", readAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		q := r.URL.Query()
+		f := journalFilter{head: q.Get("sha"), trigger: q.Get("trigger"), result: q.Get("result"), limit: 50}
+
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 500 {
+				http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+				return
+			}
+			f.limit = n
+		}
+
+		if v := q.Get("before"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, "before must be a deployment id", http.StatusBadRequest)
+				return
+			}
+			f.before = id
+		}
+
+		page := struct {
+			Deployments []attempt `json:"deployments"`
+			Next        int64     `json:"next,omitempty"`
+		}{Deployments: m.journal.list(f)}
+
+		if n := len(page.Deployments); n == f.limit {
+			page.Next = page.Deployments[n-1].ID
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}))
+
+	m.router.GET("/_events", readAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		m.events.streamEvents(w, r)
+	}))
+
+	m.router.GET("/_deployments/current/logs/stream", readAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		m.live.streamLogs(w, r)
+	}))
+
+	m.router.GET("/_logs/:sha", readAuth.require(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if !validSha(ps.ByName("sha")) {
+			http.Error(w, "sha must be a commit hash", http.StatusBadRequest)
+			return
+		}
+
+		kinds := logKinds
+		if kind := r.URL.Query().Get("kind"); kind != "" {
+			if !validLogKind(kind) {
+				http.Error(w, "kind must be build or run", http.StatusBadRequest)
+				return
+			}
+			kinds = []string{kind}
+		}
+
+		files, err := m.logFiles(ps.ByName("sha"), kinds...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, name := range files {
+			f, err := os.Open(name)
+			if err != nil {
+				logger(subWatcher).Error("Open log failed", "err", err)
+				continue
+			}
+
+			fmt.Fprintf(w, "==> %s <==\n", filepath.Base(name))
+			io.Copy(w, f)
+			f.Close()
+		}
+	}))
+
+	m.router.POST("/_reload", controlAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		restart, err := m.set.Reload()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, "Reloaded")
+		if len(restart) > 0 {
+			fmt.Fprintf(w, ", restart to apply %s", strings.Join(restart, ", "))
+		}
+	}))
+
+	m.router.POST("/_pause", controlAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		m.queue.pause()
+		logger(subWatcher).Info("Deployments paused")
+		fmt.Fprint(w, "Paused")
+	}))
+
+	m.router.POST("/_resume", controlAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		m.queue.resume()
+		logger(subWatcher).Info("Deployments resumed")
+		fmt.Fprint(w, "Resumed")
+	}))
+
+	m.router.POST("/_rollback", controlAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		// Not the request's context, a rollback goes on when its
+		// client goes away.
+		head, err := m.rollback(deployCtx)
+		if err != nil {
+			logger(subSupervisor).Error("Rollback failed", "err", err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		fmt.Fprintf(w, "Rolled back to %s", head)
+	}))
+
+	m.router.POST("/_approve", controlAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		head, err := m.approve()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		fmt.Fprintf(w, "Approved %s", head)
+	}))
+
+	m.router.POST("/_canary/promote", controlAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		head, err := m.promoteCanary()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		fmt.Fprintf(w, "Promoted %s", head)
+	}))
+
+	m.router.POST("/_canary/abort", controlAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		head, err := m.abortCanary()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		fmt.Fprintf(w, "Aborted %s", head)
+	}))
+
+	// With ?dry_run=1 the head is built and checked right away, the
+	// attempt is the response.
+	m.router.POST("/_deploy", controlAuth.require(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		req := struct {
+			Ref string `json:"ref"`
+			Sha string `json:"sha"`
+		}{}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, errors.Wrap(err, "unmarshal").Error(), http.StatusBadRequest)
+			return
+		}
+
+		head := req.Sha
+		if head != "" && !fullSha(head) {
+			http.Error(w, "sha must be a full commit hash", http.StatusBadRequest)
+			return
+		}
+		if head == "" {
+			if req.Ref == "" {
+				http.Error(w, "ref or sha required", http.StatusBadRequest)
+				return
+			}
+
+			sha, err := resolveRef(r.Context(), m.repo, req.Ref)
+			if err != nil {
+				logger(subWebhook).Error("Resolve ref failed", "ref", req.Ref, "err", err)
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			head = sha
+		}
+
+		if dry, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dry {
+			logger(subWebhook).Info("Dry run requested", "sha", head, "ip", r.RemoteAddr)
+			// Ends with the request or with the watcher.
+			ctx, cancel := context.WithCancelCause(deployCtx)
+			defer context.AfterFunc(r.Context(), func() { cancel(r.Context().Err()) })()
+			defer cancel(nil)
+
+			deploying.Add(1)
+			a := m.deploy(ctx, head, triggerManual, false, true)
+			deploying.Done()
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(a)
+			return
+		}
+
+		logger(subWebhook).Info("Manual deploy requested", "sha", head, "ip", r.RemoteAddr)
+		m.queue.push(head, triggerManual)
+		m.notify(deployEvent{kind: eventReceived, head: head, trigger: triggerManual})
+
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Deploying %s", head)
+	}))
+
+	m.router.POST("/_deploy/:sha", controlAuth.require(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if err := m.restoreRetained(deployCtx, ps.ByName("sha")); err != nil {
+			logger(subSupervisor).Error("Deploy retained build failed", "sha", ps.ByName("sha"), "err", err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		fmt.Fprintf(w, "Switched to %s", ps.ByName("sha"))
+	}))
+
+	// Everything not handled by the watcher itself, on any path and with
+	// any method, goes to the app.
+	m.router.HandleMethodNotAllowed = false
+	var app http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config().Hold > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), heldKey{}, r))
+		}
+
+		b := m.pick(w, r)
+		if b == nil || !b.alive() {
+			if b = m.awaitBackend(r, nil); b == nil {
+				config().Maintenance.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		b.ServeHTTP(w, r)
+	})
+
+	m.base = app
+}
+
+// onPush queues the head of a verified push for the app following the
+// pushed repository and branch. Pushes are routed by repository, so all
+// apps may share one webhook, an organization webhook included, which
+// -webhook-repos narrows down.
+func (m *Manager) onPush(w http.ResponseWriter, r *http.Request, push webhook.Push) {
+	repo := push.Repo
+	if repo == "" {
+		repo = m.repo
+	}
+
+	if !repoAllowed(repo) {
+		logger(subWebhook).Warn("Push of repository not in -webhook-repos", "repo", repo, "ip", config().Trusted.ClientIP(r))
+		http.Error(w, fmt.Sprintf("Repository %s is not allowed", repo), http.StatusForbidden)
+		return
+	}
+
+	if push.Deleted {
+		fmt.Fprintf(w, "Ignoring deletion of %s", push.Ref)
+		return
+	}
+
+	if target := m.set.forPush(repo, push.Ref); target != nil {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Thanks, updating to %s now", push.Head)
+		target.queue.push(push.Head, triggerPush)
+		target.notify(deployEvent{kind: eventReceived, head: push.Head, trigger: triggerPush})
+		return
+	}
+
+	logger(subWebhook).Debug("No app follows push", "repo", repo, "ref", push.Ref)
+	fmt.Fprintf(w, "Unnecessary inform, head %s", m.route().last)
+}
+
+// onWebhookError logs a rejected webhook request and bans senders of
+// wrong signatures.
+func (m *Manager) onWebhookError(r *http.Request, err error) {
+	ip := config().Trusted.ClientIP(r)
+	if err != webhook.ErrSignature {
+		logger(subWebhook).Error("Read push failed", "ip", ip, "err", err)
+		return
+	}
+
+	logger(subWebhook).Warn("Wrong signature", "ip", ip)
+	m.metrics.webhookFailure()
+	if m.bans.fail(ip, time.Now()) {
+		logger(subWebhook).Warn("Banned", "ip", ip, "duration", config().BanDuration)
+	}
+}
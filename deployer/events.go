@@ -0,0 +1,102 @@
+package deployer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventBus fans the deployment events of an app out to subscribers of
+// /_events.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan hookPayload]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: map[chan hookPayload]struct{}{}}
+}
+
+// publish sends pl to every subscriber. Subscribers too slow to keep up
+// are dropped and reconnect.
+func (e *eventBus) publish(pl hookPayload) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for ch := range e.subs {
+		select {
+		case ch <- pl:
+		default:
+			close(ch)
+			delete(e.subs, ch)
+		}
+	}
+}
+
+// subscribe returns a channel receiving the events to come.
+func (e *eventBus) subscribe() chan hookPayload {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ch := make(chan hookPayload, 64)
+	e.subs[ch] = struct{}{}
+
+	return ch
+}
+
+// unsubscribe stops sending to ch.
+func (e *eventBus) unsubscribe(ch chan hookPayload) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.subs[ch]; ok {
+		close(ch)
+		delete(e.subs, ch)
+	}
+}
+
+// streamEvents streams the events of e to w as server-sent events, named
+// like hook events and carrying the hook payload, until the client goes
+// away.
+func (e *eventBus) streamEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	ch := e.subscribe()
+	defer e.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case pl, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			body, err := json.Marshal(pl)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\n", pl.ID)
+			writeEvent(w, pl.Event, body)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
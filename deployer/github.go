@@ -0,0 +1,257 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/romanyx/watcher/proxy"
+)
+
+const (
+	// githubAPI is the base URL of the GitHub API.
+	githubAPI = "https://api.github.com"
+
+	// githubRetryBase is the delay before the first retry of a failed
+	// GitHub request, doubled for each further one.
+	githubRetryBase = time.Second
+
+	// githubMaxLimitWait is the longest rate limit waited out.
+	githubMaxLimitWait = time.Minute
+
+	// githubCacheSize bounds the answers kept for conditional requests.
+	githubCacheSize = 256
+)
+
+// errRateLimited carries when GitHub may be asked again.
+type errRateLimited struct {
+	until time.Time
+}
+
+func (e errRateLimited) Error() string {
+	return fmt.Sprintf("rate limited until %s", e.until.Format(time.RFC3339))
+}
+
+// githubHooks are the hook ranges last fetched from GitHub's meta API for
+// -allow rules naming github.
+var githubHooks struct {
+	proxy.HookRanges
+	refresh sync.Once
+}
+
+// githubCache keeps the last answer to each path with its ETag, so
+// unchanged ones are asked for conditionally and don't count against the
+// rate limit.
+var githubCache = struct {
+	mu      sync.Mutex
+	entries map[string]githubEntry
+}{entries: map[string]githubEntry{}}
+
+type githubEntry struct {
+	etag, next string
+	body       []byte
+}
+
+// githubLimit is until when the rate limit of GitHub is used up.
+var githubLimit struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// githubGet requests path from the GitHub API and decodes the JSON
+// response into v. Network errors and server errors are retried
+// -github-retries times with exponential backoff and jitter. Rate limits
+// ending within a minute and before ctx are waited out, otherwise
+// errRateLimited is returned.
+func githubGet(ctx context.Context, path string, v interface{}) error {
+	_, err := githubPage(ctx, path, v)
+	return err
+}
+
+// githubPage is githubGet for listings. It returns the path of the next
+// page, by the Link header, or "" on the last one.
+func githubPage(ctx context.Context, path string, v interface{}) (string, error) {
+	for attempt := 0; ; attempt++ {
+		retry, next, err := githubTry(ctx, path, v)
+		if err == nil || !retry || attempt >= config().GithubRetries {
+			return next, err
+		}
+
+		wait := githubRetryBase << uint(attempt)
+		wait += time.Duration(rand.Int63n(int64(wait)))
+
+		var limited errRateLimited
+		if errors.As(err, &limited) {
+			wait = time.Until(limited.until)
+			if wait > githubMaxLimitWait {
+				return "", err
+			}
+			if deadline, ok := ctx.Deadline(); ok && limited.until.After(deadline) {
+				return "", err
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return "", err
+		}
+	}
+}
+
+// githubTry makes one request of githubPage. It reports whether a failure
+// is worth retrying.
+func githubTry(ctx context.Context, path string, v interface{}) (bool, string, error) {
+	githubLimit.mu.Lock()
+	until := githubLimit.until
+	githubLimit.mu.Unlock()
+	if time.Now().Before(until) {
+		return true, "", errRateLimited{until: until}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, githubAPI+path, nil)
+	if err != nil {
+		return false, "", errors.Wrap(err, "new request")
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if config().GithubToken != "" {
+		req.Header.Set("Authorization", "token "+config().GithubToken)
+	}
+
+	githubCache.mu.Lock()
+	cached, ok := githubCache.entries[path]
+	githubCache.mu.Unlock()
+	if ok {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, "", errors.Wrap(err, "get request")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+		return false, cached.next, errors.Wrap(json.Unmarshal(cached.body, v), "unmarshal json")
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusForbidden && resp.Header.Get("X-RateLimit-Remaining") == "0":
+		until := rateLimitReset(resp.Header)
+		githubLimit.mu.Lock()
+		githubLimit.until = until
+		githubLimit.mu.Unlock()
+		logger(subWatcher).Warn("GitHub rate limit reached", "until", until)
+		return true, "", errRateLimited{until: until}
+	case resp.StatusCode >= 500:
+		return true, "", errors.Errorf("get request %v", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return false, "", errors.Errorf("get request %v", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, "", errors.Wrap(err, "read body")
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return false, "", errors.Wrap(err, "unmarshal json")
+	}
+
+	next := nextPage(resp.Header)
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		githubCache.mu.Lock()
+		if len(githubCache.entries) >= githubCacheSize {
+			githubCache.entries = map[string]githubEntry{}
+		}
+		githubCache.entries[path] = githubEntry{etag: etag, next: next, body: body}
+		githubCache.mu.Unlock()
+	}
+
+	return false, next, nil
+}
+
+// nextPage returns the path of the rel="next" link in h, or "".
+func nextPage(h http.Header) string {
+	for _, link := range strings.Split(h.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) != `rel="next"` {
+				continue
+			}
+
+			target := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			if !strings.HasPrefix(target, githubAPI+"/") {
+				return ""
+			}
+			return strings.TrimPrefix(target, githubAPI)
+		}
+	}
+
+	return ""
+}
+
+// rateLimitReset returns when a rate limited client may ask again, by
+// Retry-After or X-RateLimit-Reset, a minute from now without either.
+func rateLimitReset(h http.Header) time.Time {
+	if s, err := strconv.Atoi(h.Get("Retry-After")); err == nil {
+		return time.Now().Add(time.Duration(s) * time.Second)
+	}
+
+	if s, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		return time.Unix(s, 0)
+	}
+
+	return time.Now().Add(time.Minute)
+}
+
+// startHookRefresh starts fetching GitHub's webhook ranges now and then
+// every interval, once per process. Until the first fetch succeeds
+// requests limited to them are denied.
+func startHookRefresh(interval time.Duration) {
+	githubHooks.refresh.Do(func() {
+		go func() {
+			for {
+				if err := fetchHooks(); err != nil {
+					logger(subWebhook).Error("Fetch GitHub hook ranges failed", "err", err)
+				}
+
+				time.Sleep(interval)
+			}
+		}()
+	})
+}
+
+func fetchHooks() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	meta := struct {
+		Hooks []string `json:"hooks"`
+	}{}
+
+	if err := githubGet(ctx, "/meta", &meta); err != nil {
+		return err
+	}
+
+	nets, err := proxy.ParseCIDRs(strings.Join(meta.Hooks, ","))
+	if err != nil {
+		return err
+	}
+	githubHooks.Set(nets)
+
+	return nil
+}
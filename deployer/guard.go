@@ -0,0 +1,112 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// counts returns the requests proxied to the replicas of b and how many of
+// them failed with 5xx or didn't reach an instance.
+func (b *backend) counts() (requests, failures int64) {
+	for _, r := range b.replicas() {
+		requests += atomic.LoadInt64(&r.requests)
+		failures += atomic.LoadInt64(&r.failures)
+	}
+
+	return requests, failures
+}
+
+// guard watches b for -rollback-window after it started serving all
+// traffic. It rolls back when more than -rollback-error-rate percent of at
+// least -rollback-min-requests requests fail, or when -health-path fails
+// -rollback-health-failures times in a row.
+func (m *Manager) guard(b *backend) {
+	if config().RollbackWindow <= 0 {
+		return
+	}
+
+	// A promoted canary has served requests before.
+	baseReqs, baseFails := b.counts()
+
+	window := time.After(config().RollbackWindow)
+
+	tick := time.NewTicker(config().HealthInterval)
+	defer tick.Stop()
+
+	client := &http.Client{Transport: b.transport, Timeout: config().HealthInterval}
+	healthURL := strings.TrimSuffix(b.base.String(), "/") + config().HealthPath
+	unhealthy := 0
+
+	for {
+		select {
+		case <-window:
+			return
+		case <-b.done:
+			// Crashes are the supervisor's.
+			return
+		case <-deployCtx.Done():
+			return
+		case <-tick.C:
+		}
+
+		if atomic.LoadInt32(&b.stopping) == 1 {
+			return
+		}
+
+		reqs, fails := b.counts()
+		reqs, fails = reqs-baseReqs, fails-baseFails
+		if reqs >= int64(config().RollbackMinRequests) && fails*100 > int64(config().RollbackErrorRate)*reqs {
+			m.autoRollback(b, fmt.Sprintf("%d of %d requests failed after the switch", fails, reqs))
+			return
+		}
+
+		if config().HealthPath == "" {
+			continue
+		}
+
+		err := probe(context.Background(), client, healthURL)
+		if err == nil {
+			unhealthy = 0
+			continue
+		}
+
+		unhealthy++
+		if unhealthy >= config().RollbackHealthFailures {
+			m.autoRollback(b, fmt.Sprintf("health check failed %d times after the switch: %v", unhealthy, err))
+			return
+		}
+	}
+}
+
+// autoRollback rolls back from the build of b, which went bad after the
+// switch for reason.
+func (m *Manager) autoRollback(b *backend, reason string) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.backend != b {
+		return
+	}
+
+	bad := m.last
+	m.failure = reason
+	m.notify(deployEvent{kind: eventFailed, head: bad, err: reason})
+
+	if len(m.history) < 2 {
+		m.logger(subSupervisor).Error("Build went bad and there is nothing to roll back to", "reason", reason)
+		return
+	}
+
+	if err := m.rollBack(deployCtx); err != nil {
+		m.logger(subSupervisor).Error("Automatic rollback failed", "err", err)
+		return
+	}
+	m.failure = "rolled back from " + bad + ": " + reason
+
+	m.logger(subSupervisor).Warn("Rolled back automatically", "from", bad, "reason", reason)
+	m.notify(deployEvent{kind: eventRolledBack, head: m.last, from: bad, err: reason})
+}
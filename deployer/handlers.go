@@ -0,0 +1,84 @@
+package deployer
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/romanyx/watcher/proxy"
+)
+
+// handlers are the parts of request handling built from reloadable
+// settings.
+type handlers struct {
+	// app wraps the proxied app, front the router.
+	app, front http.Handler
+}
+
+// buildHandlers builds the handlers of m from the settings of c.
+func (m *Manager) buildHandlers(c *Config) (*handlers, error) {
+	app := m.base
+
+	if len(c.Routes) > 0 {
+		t := c.Transport.New()
+		upstream := func(u *url.URL) http.Handler {
+			return proxy.Upstream(u, t, c.Trusted)
+		}
+
+		routes, err := proxy.ParseRoutes(c.Routes, app, upstream)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid -route")
+		}
+		app = proxy.RouteByPrefix(routes, app)
+	}
+
+	if len(c.RequestHeaders) > 0 || len(c.ResponseHeaders) > 0 {
+		req, err := proxy.ParseHeaderRules(c.RequestHeaders)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid -request-header")
+		}
+
+		resp, err := proxy.ParseHeaderRules(c.ResponseHeaders)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid -response-header")
+		}
+		app = proxy.RewriteHeaders(req, resp, app)
+	}
+
+	if c.CacheControl != "" {
+		rules, err := proxy.ParseCacheRules(c.CacheControl)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid -cache-control")
+		}
+		app = proxy.CacheControl(rules, app)
+	}
+
+	if c.Gzip {
+		app = proxy.Gzip(app)
+	}
+
+	app = instrument(m.metrics, app)
+
+	if tracing != nil && c.TraceRequests {
+		app = traceRequests(app)
+	}
+
+	if c.AccessLog != nil {
+		app = c.AccessLog.Wrap(app)
+	}
+
+	var front http.Handler = m.router
+	if len(c.Allow) > 0 {
+		a, err := proxy.ParseAllowRules(c.Allow, c.Trusted, &githubHooks.HookRanges)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid -allow")
+		}
+
+		if a.UsesGithub() {
+			startHookRefresh(c.AllowRefresh)
+		}
+		front = a.Wrap(front)
+	}
+
+	return &handlers{app: app, front: front}, nil
+}
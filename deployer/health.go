@@ -0,0 +1,84 @@
+package deployer
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// waitHealthy probes base+healthPath until it answered with 2xx
+// -health-threshold times in a row. It fails when the probe does not
+// succeed within -health-timeout or when the process of b exits meanwhile.
+func waitHealthy(ctx context.Context, b *backend) error {
+	if config().HealthPath == "" {
+		return nil
+	}
+
+	client := &http.Client{Transport: b.transport, Timeout: config().HealthInterval}
+	base := strings.TrimSuffix(b.base.String(), "/")
+
+	return probeHealthy(ctx, client, base, b.done, func() error {
+		if b.err == nil {
+			return errors.New("process exited before becoming healthy")
+		}
+		return errors.Wrap(b.err, "process exited before becoming healthy")
+	})
+}
+
+// probeHealthy probes base+healthPath with client like waitHealthy. It
+// fails with exited() once done is closed, done may be nil.
+func probeHealthy(ctx context.Context, client *http.Client, base string, done <-chan struct{}, exited func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, config().HealthTimeout)
+	defer cancel()
+
+	var (
+		successes int
+		lastErr   error
+	)
+
+	for {
+		lastErr = probe(ctx, client, base+config().HealthPath)
+		if lastErr == nil {
+			successes++
+		} else {
+			successes = 0
+		}
+
+		if successes >= config().HealthThreshold {
+			return nil
+		}
+
+		select {
+		case <-done:
+			return exited()
+		case <-ctx.Done():
+			if lastErr == nil {
+				lastErr = ctx.Err()
+			}
+			return errors.Wrapf(lastErr, "not healthy after %s", config().HealthTimeout)
+		case <-time.After(config().HealthInterval):
+		}
+	}
+}
+
+func probe(ctx context.Context, client *http.Client, u string) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "get request")
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("get request %v", resp.Status)
+	}
+
+	return nil
+}
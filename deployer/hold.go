@@ -0,0 +1,76 @@
+package deployer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// heldKey marks requests which already waited for an instance, so they are
+// retried once at most. Its value is the request as received.
+type heldKey struct{}
+
+// awaitBackend waits up to -hold for an instance other than not which is
+// able to serve r. It returns nil when there is none.
+func (m *Manager) awaitBackend(r *http.Request, not *backend) *backend {
+	deadline := time.Now().Add(config().Hold)
+
+	for {
+		if b := m.pick(nil, r); b != nil && b != not && b.alive() {
+			return b
+		}
+
+		if time.Now().After(deadline) {
+			return nil
+		}
+
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// retryElsewhere serves a request which could not reach failed by another
+// instance, waiting up to -hold for one to come up. It reports whether it
+// served the request.
+func (m *Manager) retryElsewhere(w http.ResponseWriter, outreq *http.Request, failed *backend, err error) bool {
+	if config().Hold <= 0 || !isDialError(err) {
+		return false
+	}
+
+	r, ok := outreq.Context().Value(heldKey{}).(*http.Request)
+	if !ok || r.Context().Value(retriedKey{}) != nil {
+		return false
+	}
+
+	b := m.awaitBackend(r, failed)
+	if b == nil {
+		return false
+	}
+
+	b.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), retriedKey{}, true)))
+
+	return true
+}
+
+// retryFor returns the retry hook of b.
+func (m *Manager) retryFor(b *backend) func(http.ResponseWriter, *http.Request, error) bool {
+	return func(w http.ResponseWriter, r *http.Request, err error) bool {
+		return m.retryElsewhere(w, r, b, err)
+	}
+}
+
+// retriedKey marks requests which were retried already.
+type retriedKey struct{}
+
+// isDialError reports whether err happened before the request was sent, so
+// it is safe to send it again.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,124 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// hookAttempts is how often an event is posted to a hook before giving up.
+const hookAttempts = 3
+
+// hookPayload is the JSON body posted to -event-hook URLs. Its fields are
+// only ever added to.
+type hookPayload struct {
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	Repo       string    `json:"repo"`
+	Sha        string    `json:"sha"`
+	From       string    `json:"from,omitempty"`
+	Trigger    string    `json:"trigger,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// hookEvent names ev the way hook payloads do, like rolled_back.
+func hookEvent(kind string) string {
+	return strings.Replace(kind, " ", "_", -1)
+}
+
+// payload describes ev to event hooks and plugins.
+func (m *Manager) payload(ev deployEvent) hookPayload {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return hookPayload{
+		ID:         hex.EncodeToString(id),
+		Event:      hookEvent(ev.kind),
+		Repo:       m.repo,
+		Sha:        ev.head,
+		From:       ev.from,
+		Trigger:    ev.trigger,
+		Error:      ev.err,
+		DurationMs: int64(ev.duration / time.Millisecond),
+		Timestamp:  time.Now().UTC(),
+	}
+}
+
+// postHooks posts ev to every -event-hook in the background, signing the
+// body with -event-hook-secret, or -secret, in X-Watcher-Signature.
+func (m *Manager) postHooks(ev deployEvent) {
+	if len(config().EventHooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(m.payload(ev))
+	if err != nil {
+		logger(subWatcher).Error("Marshal hook payload failed", "err", err)
+		return
+	}
+
+	key := config().EventHookSecret
+	if key == "" {
+		key = config().Secret
+	}
+
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(body)
+	sign := "sha256=" + hex.EncodeToString(h.Sum(nil))
+
+	for _, u := range config().EventHooks {
+		go func(u string) {
+			backoff := time.Second
+			for i := 1; ; i++ {
+				err := postHook(u, body, sign)
+				if err == nil {
+					return
+				}
+
+				if i == hookAttempts {
+					logger(subWatcher).Warn("Event hook failed", "url", u, "event", hookEvent(ev.kind), "err", err)
+					return
+				}
+
+				time.Sleep(backoff)
+				backoff *= 4
+			}
+		}(u)
+	}
+}
+
+func postHook(u string, body []byte, sign string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Watcher-Signature", sign)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "post request")
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("post request %v", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package deployer
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Triggers and results of deployment attempts.
+const (
+	triggerPush    = "push"
+	triggerManual  = "manual"
+	triggerStartup = "startup"
+	triggerPoll    = "poll"
+	triggerWatch   = "watch"
+	triggerCron    = "schedule"
+
+	resultSuccess   = "success"
+	resultFailure   = "failure"
+	resultCancelled = "cancelled"
+)
+
+// stageTime is how long one stage of an attempt took.
+type stageTime struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// attempt is one deployment attempt as recorded in the journal.
+type attempt struct {
+	ID      int64       `json:"id"`
+	Head    string      `json:"sha"`
+	Trigger string      `json:"trigger"`
+	Started time.Time   `json:"started"`
+	Ended   time.Time   `json:"ended"`
+	Result  string      `json:"result"`
+	Stages  []stageTime `json:"stages"`
+	Error   string      `json:"error,omitempty"`
+	Output  string      `json:"output,omitempty"`
+	DryRun  bool        `json:"dry_run,omitempty"`
+
+	// Image is the digest of the image pushed by -builder=docker.
+	Image string `json:"image,omitempty"`
+
+	// Annotations are added by plugins.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// stage records that stage name took since start.
+func (a *attempt) stage(name string, start time.Time) {
+	a.Stages = append(a.Stages, stageTime{Name: name, Duration: time.Since(start).Seconds()})
+}
+
+// journal keeps every deployment attempt, appended as JSON lines to
+// -journal when set.
+type journal struct {
+	mu       sync.Mutex
+	path     string
+	attempts []attempt
+	lastID   int64
+}
+
+// openJournal loads the attempts recorded in path, which may not exist
+// yet. An empty path keeps attempts in memory only.
+func openJournal(path string) (*journal, error) {
+	j := &journal{path: path}
+	if path == "" {
+		return j, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "open journal")
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var a attempt
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			return nil, errors.Wrap(err, "unmarshal journal entry")
+		}
+		j.attempts = append(j.attempts, a)
+		if a.ID > j.lastID {
+			j.lastID = a.ID
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, errors.Wrap(err, "read journal")
+	}
+
+	return j, nil
+}
+
+// begin starts an attempt to deploy head.
+func (j *journal) begin(head, trigger string) *attempt {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.lastID++
+
+	return &attempt{ID: j.lastID, Head: head, Trigger: trigger, Started: time.Now()}
+}
+
+// record ends a and appends it to the journal.
+func (j *journal) record(a *attempt) {
+	a.Ended = time.Now()
+	if a.Result == "" {
+		a.Result = resultFailure
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.attempts = append(j.attempts, *a)
+
+	if j.path == "" {
+		return
+	}
+
+	line, err := json.Marshal(a)
+	if err != nil {
+		logger(subBuilder).Error("Marshal journal entry failed", "err", err)
+		return
+	}
+
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		logger(subBuilder).Error("Open journal failed", "err", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		logger(subBuilder).Error("Write journal failed", "err", err)
+	}
+}
+
+// journalFilter selects attempts for GET /_deployments.
+type journalFilter struct {
+	head, trigger, result string
+	before                int64
+	limit                 int
+}
+
+// list returns the attempts matching f, newest first.
+func (j *journal) list(f journalFilter) []attempt {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	found := []attempt{}
+	for i := len(j.attempts) - 1; i >= 0 && len(found) < f.limit; i-- {
+		a := j.attempts[i]
+		switch {
+		case f.before > 0 && a.ID >= f.before:
+		case f.head != "" && !strings.HasPrefix(a.Head, f.head):
+		case f.trigger != "" && a.Trigger != f.trigger:
+		case f.result != "" && a.Result != f.result:
+		default:
+			found = append(found, a)
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,73 @@
+package deployer
+
+import (
+	"context"
+	"io"
+
+	"github.com/romanyx/watcher/builder"
+)
+
+// kubeData is available to the -k8s-image template.
+type kubeData struct {
+	Image string
+	Sha   string
+}
+
+// kubeEnabled reports whether builds are rolled out to -k8s-deployment
+// instead of being started locally.
+func kubeEnabled() bool {
+	return config().K8sDeployment != ""
+}
+
+// kubectl returns the step running kubectl with args for -kubeconfig and
+// -k8s-namespace.
+func kubectl(args ...string) builder.Step {
+	step := builder.Step{"kubectl"}
+	if config().Kubeconfig != "" {
+		step = append(step, "--kubeconfig="+config().Kubeconfig)
+	}
+	if config().K8sNamespace != "" {
+		step = append(step, "--namespace="+config().K8sNamespace)
+	}
+
+	return append(step, args...)
+}
+
+// kubeImage returns the image of head, rendered from -k8s-image.
+func (m *Manager) kubeImage(head string) (string, error) {
+	return render("k8s-image", config().K8sImage, kubeData{Image: m.dockerRepo(), Sha: head})
+}
+
+// kubeSteps returns the steps setting the image of -k8s-deployment and
+// waiting for the rollout. A dry run has the change validated by the
+// server only.
+func kubeSteps(image string, dry bool) []builder.Step {
+	deployment := "deployment/" + config().K8sDeployment
+
+	set := kubectl("set", "image", deployment, config().K8sContainer+"="+image)
+	if dry {
+		return []builder.Step{append(set, "--dry-run=server")}
+	}
+
+	return []builder.Step{
+		set,
+		kubectl("rollout", "status", deployment, "--timeout="+config().K8sTimeout.String()),
+	}
+}
+
+// rollOut rolls the image of head out to -k8s-deployment, writing the
+// output of kubectl to out.
+func (m *Manager) rollOut(ctx context.Context, head string, out io.Writer) error {
+	image, err := m.kubeImage(head)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range kubeSteps(image, false) {
+		if err := builder.Run(ctx, "", stepEnv(), out, step); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,259 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// leaseRecord is the content of a lease file on shared storage: the
+// watcher instance holding it and until when.
+type leaseRecord struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// turnRecord is what an instance last deployed, written next to the lease
+// for the followers.
+type turnRecord struct {
+	Head   string `json:"sha"`
+	Result string `json:"result"`
+}
+
+// leading is 1 while this instance holds -lease.
+var leading int32
+
+// coordinating reports whether deployments are coordinated through -lease.
+func coordinating() bool {
+	return config().Lease != ""
+}
+
+// isLeader reports whether this instance leads the deployments.
+func isLeader() bool {
+	return !coordinating() || atomic.LoadInt32(&leading) == 1
+}
+
+// instanceID returns -instance-id, the host name by default.
+func instanceID() string {
+	if config().InstanceID != "" {
+		return config().InstanceID
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return strconv.Itoa(os.Getpid())
+	}
+
+	return host
+}
+
+func readJSON(path string, v interface{}) error {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// writeJSON replaces the file at path with v, through a file private to
+// this instance so readers never see a partial one.
+func writeJSON(path string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + "." + instanceID() + ".tmp"
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// tryLease takes or renews the lease at path for ttl. It reports whether
+// this instance holds it.
+func tryLease(path string, ttl time.Duration) (bool, error) {
+	id := instanceID()
+
+	var l leaseRecord
+	err := readJSON(path, &l)
+	if err != nil && !os.IsNotExist(err) {
+		return false, errors.Wrap(err, "read lease")
+	}
+
+	if l.Holder != id && time.Now().Before(l.Expires) {
+		return false, nil
+	}
+
+	if err := writeJSON(path, leaseRecord{Holder: id, Expires: time.Now().Add(ttl)}); err != nil {
+		return false, errors.Wrap(err, "write lease")
+	}
+
+	// Another instance may have written at the same time, the last
+	// rename wins.
+	if err := readJSON(path, &l); err != nil {
+		return false, errors.Wrap(err, "read lease")
+	}
+
+	return l.Holder == id, nil
+}
+
+// releaseLease gives up the lease at path when this instance holds it.
+func releaseLease(path string) {
+	var l leaseRecord
+	if err := readJSON(path, &l); err == nil && l.Holder == instanceID() {
+		os.Remove(path)
+	}
+}
+
+// leaseLoop keeps taking or renewing -lease. It must be started once.
+func leaseLoop() {
+	l := logger(subWatcher).With("instance", instanceID())
+
+	for {
+		ok, err := tryLease(config().Lease, config().LeaseTTL)
+		if err != nil {
+			l.Error("Lease failed", "err", err)
+		}
+
+		var now int32
+		if ok {
+			now = 1
+		}
+		if atomic.SwapInt32(&leading, now) != now {
+			if ok {
+				l.Info("Leading deployments")
+			} else {
+				l.Info("Following deployments")
+			}
+		}
+
+		select {
+		case <-time.After(config().LeaseTTL / 3):
+		case <-deployCtx.Done():
+			if ok {
+				releaseLease(config().Lease)
+			}
+			return
+		}
+	}
+}
+
+// turnPath returns the file the instances take turns deploying m with.
+func (m *Manager) turnPath() string {
+	return config().Lease + "." + m.name + ".turn"
+}
+
+// recordPath returns the file instance id records its deployment of m in.
+func (m *Manager) recordPath(id string) string {
+	return config().Lease + "." + m.name + "." + id
+}
+
+// leaderDeployed waits until the leader recorded the result of deploying
+// head, for at most -build-timeout.
+func (m *Manager) leaderDeployed(ctx context.Context, head string) error {
+	ctx, cancel := context.WithTimeout(ctx, config().BuildTimeout)
+	defer cancel()
+
+	for !isLeader() {
+		var l leaseRecord
+		var r turnRecord
+		if readJSON(config().Lease, &l) == nil && readJSON(m.recordPath(l.Holder), &r) == nil && r.Head == head {
+			switch r.Result {
+			case resultSuccess:
+				return nil
+			case resultFailure:
+				return errors.Errorf("leader %s failed to deploy", l.Holder)
+			}
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				logger(subBuilder).Warn("Leader did not deploy in time, going ahead", "sha", head)
+				return nil
+			}
+			return context.Cause(ctx)
+		}
+	}
+
+	return nil
+}
+
+// takeTurn waits until the leader deployed the head of a and no other
+// instance is deploying. It reports whether a may go on. Dry runs and
+// uncoordinated watchers go on right away.
+func (m *Manager) takeTurn(ctx context.Context, a *attempt) bool {
+	if a.DryRun || !coordinating() {
+		return true
+	}
+
+	start := time.Now()
+	ctx, sp := startSpan(ctx, "turn")
+	err := m.leaderDeployed(ctx, a.Head)
+	for err == nil {
+		var ok bool
+		ok, err = tryLease(m.turnPath(), config().BuildTimeout+config().LeaseTTL)
+		if ok || err != nil {
+			break
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			err = context.Cause(ctx)
+		}
+	}
+	sp.finish(err)
+	a.stage("turn", start)
+	if err == nil {
+		return true
+	}
+
+	if ctx.Err() == context.Canceled {
+		logger(subBuilder).Info("Waiting for turn cancelled", "sha", a.Head, "cause", context.Cause(ctx))
+		a.Result = resultCancelled
+		return false
+	}
+
+	a.Error = err.Error()
+
+	logger(subBuilder).Error("Waiting for turn failed", "sha", a.Head, "err", err)
+
+	m.mu.Lock()
+	m.failure = err.Error()
+	m.unlock()
+
+	return false
+}
+
+// endTurn records the result of a for the followers and lets the next
+// instance deploy.
+func (m *Manager) endTurn(a *attempt) {
+	if a.DryRun || !coordinating() {
+		return
+	}
+
+	m.recordTurn(a.Head, a.Result)
+	releaseLease(m.turnPath())
+}
+
+// recordTurn records that this instance deployed head with result.
+func (m *Manager) recordTurn(head, result string) {
+	if !coordinating() {
+		return
+	}
+
+	if err := writeJSON(m.recordPath(instanceID()), turnRecord{Head: head, Result: result}); err != nil {
+		logger(subBuilder).Error("Record deployment failed", "err", err)
+	}
+}
@@ -0,0 +1,29 @@
+package deployer
+
+import "log/slog"
+
+// Subsystems tagged on log lines.
+const (
+	subWatcher    = "watcher"
+	subWebhook    = "webhook"
+	subBuilder    = "builder"
+	subProxy      = "proxy"
+	subSupervisor = "supervisor"
+)
+
+// logger returns the logger of subsystem.
+func logger(subsystem string) *slog.Logger {
+	return slog.Default().With("subsystem", subsystem)
+}
+
+// logger returns the logger of subsystem tagged with the app, its serving
+// build and side. The caller must hold m.mu.
+func (m *Manager) logger(subsystem string) *slog.Logger {
+	return logger(subsystem).With("app", m.name, "sha", m.last, "side", m.side)
+}
+
+// logger returns the logger of subsystem tagged with the build and side of
+// c.
+func (c *candidate) logger(subsystem string) *slog.Logger {
+	return logger(subsystem).With("sha", c.deployment.head, "side", c.side)
+}
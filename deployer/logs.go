@@ -0,0 +1,189 @@
+package deployer
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var errNoLogs = errors.New("no logs")
+
+// outputTail is how much of the end of its build output a failed attempt
+// keeps.
+const outputTail = 8 << 10
+
+// nopCloser is the output of m when deployment logs are not captured.
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// logsDir returns the directory the logs of m are kept in, empty without
+// -logs-dir.
+func (m *Manager) logsDir() string {
+	if config().LogsDir == "" {
+		return ""
+	}
+
+	return filepath.Join(config().LogsDir, m.logs)
+}
+
+// openLog opens the log kind ("build" or "run") of head for appending. All
+// output goes to the output of m when -logs-dir is not set.
+func (m *Manager) openLog(head, kind string) (io.WriteCloser, error) {
+	if m.logsDir() == "" {
+		return nopCloser{m.output}, nil
+	}
+
+	dir := filepath.Join(m.logsDir(), head)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create logs directory")
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, kind+".log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open log")
+	}
+
+	return f, nil
+}
+
+// logKinds are the logs kept per deployment, build log first.
+var logKinds = []string{"build", "run"}
+
+// validLogKind reports whether kind is one of logKinds.
+func validLogKind(kind string) bool {
+	for _, k := range logKinds {
+		if kind == k {
+			return true
+		}
+	}
+
+	return false
+}
+
+// logFiles returns the existing log files of head, build log first.
+func (m *Manager) logFiles(head string, kinds ...string) ([]string, error) {
+	if m.logsDir() == "" || !validSha(head) {
+		return nil, errNoLogs
+	}
+
+	var files []string
+	for _, kind := range kinds {
+		if !validLogKind(kind) {
+			return nil, errNoLogs
+		}
+
+		name := filepath.Join(m.logsDir(), head, kind+".log")
+		if _, err := os.Stat(name); err == nil {
+			files = append(files, name)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errNoLogs
+	}
+
+	return files, nil
+}
+
+// pruneLogs removes the logs of all but the -logs-retain most recent
+// deployments of m. The logs of retained builds are kept. The caller must
+// hold m.mu.
+func (m *Manager) pruneLogs() {
+	dir := m.logsDir()
+	if dir == "" {
+		return
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		logger(subBuilder).Error("Read logs directory failed", "err", err)
+		return
+	}
+
+	keep := map[string]bool{m.last: true}
+	for _, d := range m.history {
+		keep[d.head] = true
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+
+	n := 0
+	for _, info := range infos {
+		// Only the directories of deployments are pruned.
+		if !info.IsDir() || !validSha(info.Name()) {
+			continue
+		}
+
+		n++
+		if n <= config().LogsRetain || keep[info.Name()] {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(dir, info.Name())); err != nil {
+			logger(subBuilder).Error("Remove expired logs failed", "dir", info.Name(), "err", err)
+		}
+	}
+}
+
+// validSha reports whether s looks like a commit hash, so it is safe to
+// use as a file name.
+func validSha(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fullSha reports whether s is a full 40 character commit hash.
+func fullSha(s string) bool {
+	return len(s) == 40 && validSha(s)
+}
+
+// tailBuffer keeps the last bytes written to it.
+type tailBuffer struct {
+	mu   sync.Mutex
+	size int
+	buf  []byte
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{size: size}
+}
+
+func (t *tailBuffer) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, b...)
+	if len(t.buf) > t.size {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.size:]...)
+	}
+
+	return len(b), nil
+}
+
+// String returns the kept bytes.
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return string(t.buf)
+}
@@ -0,0 +1,103 @@
+package deployer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// mailLogLimit is how much of the end of a log is inlined into mails.
+const mailLogLimit = 64 << 10
+
+// mailing reports whether failure mails are configured.
+func mailing() bool {
+	return config().SMTPAddr != "" && config().MailFrom != "" && config().MailTo != ""
+}
+
+// mailEvent mails ev with the end of the log of the failed build or the
+// crashing instance.
+func (m *Manager) mailEvent(ev deployEvent) error {
+	kind := "build"
+	if ev.kind == eventCrashing {
+		kind = "run"
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "Deploy of %s@%s %s at %s.\n", m.repo, ev.head, ev.kind, time.Now().Format(time.RFC1123Z))
+	if ev.err != "" {
+		fmt.Fprintf(&body, "\nError: %s\n", ev.err)
+	}
+
+	if out, err := m.logTail(ev.head, kind); err == nil {
+		fmt.Fprintf(&body, "\nEnd of the %s log:\n\n%s\n", kind, out)
+	} else if ev.output != "" {
+		fmt.Fprintf(&body, "\nEnd of the %s output:\n\n%s\n", kind, ev.output)
+	}
+
+	subject := fmt.Sprintf("[watcher] %s@%s %s", m.repo, shortSha(ev.head), ev.kind)
+
+	return sendMail(subject, body.Bytes())
+}
+
+// logTail returns up to mailLogLimit bytes of the end of the log kind of
+// head.
+func (m *Manager) logTail(head, kind string) ([]byte, error) {
+	files, err := m.logFiles(head, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "open log")
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err == nil && info.Size() > mailLogLimit {
+		f.Seek(-mailLogLimit, io.SeekEnd)
+	}
+
+	return io.ReadAll(f)
+}
+
+// sendMail sends a plain text mail to -mail-to over -smtp-addr,
+// authenticating when -smtp-user is set.
+func sendMail(subject string, body []byte) error {
+	var to []string
+	for _, addr := range strings.Split(config().MailTo, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", config().MailFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprint(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprint(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.Write(bytes.ReplaceAll(body, []byte("\n"), []byte("\r\n")))
+
+	var auth smtp.Auth
+	if config().SMTPUser != "" {
+		host, _, err := net.SplitHostPort(config().SMTPAddr)
+		if err != nil {
+			return errors.Wrap(err, "parse smtp address")
+		}
+		auth = smtp.PlainAuth("", config().SMTPUser, config().SMTPPassword, host)
+	}
+
+	if err := smtp.SendMail(config().SMTPAddr, auth, config().MailFrom, to, msg.Bytes()); err != nil {
+		return errors.Wrap(err, "send mail")
+	}
+
+	return nil
+}
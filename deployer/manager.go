@@ -0,0 +1,120 @@
+// Package deployer builds the commits of apps, runs the builds side by
+// side and switches the traffic of each app over to its newest build.
+package deployer
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// Manager builds and deploys the commits of one app and proxies its
+// traffic.
+type Manager struct {
+	set    *Set
+	router *httprouter.Router
+
+	repo, binn string
+
+	// name, branch, ports and hosts come from the -app of m, statePath is
+	// where its state is kept, logs its directory within -logs-dir.
+	name, branch, ports string
+	hosts               []string
+	statePath, logs     string
+
+	// redeploy and restart are the schedules given with the -app of m,
+	// overriding -redeploy-schedule and -restart-schedule.
+	redeploy, restart string
+
+	mu        sync.Mutex
+	last, dir string
+	side      int
+	backend   *backend
+	failure   string
+
+	// history holds the retained builds, newest first. rolledBack is
+	// the last head rolled back from.
+	history    []*deployment
+	rolledBack string
+
+	canary *candidate
+	staged *staged
+
+	// routing is the snapshot of backend and canary requests are routed
+	// by and of the state above, see reroute.
+	routing atomic.Value
+
+	// crashes counts all crashes of instances, consecutive the ones of
+	// the build serving now.
+	crashes, consecutive int
+
+	queue   *deployQueue
+	phase   phase
+	journal *journal
+	live    *liveLog
+	events  *eventBus
+
+	// base is the proxied app, handlers the handling around it built
+	// from reloadable settings.
+	base     http.Handler
+	handlers atomic.Value
+
+	// output gets the output of builds and instances without
+	// -logs-dir, stdout by default.
+	output io.Writer
+
+	// bans and pushLimit guard the webhook.
+	bans      *banList
+	pushLimit *rateLimiter
+
+	metrics *metricSet
+}
+
+// newManager returns the manager of app.
+func newManager(app App) *Manager {
+	return &Manager{
+		side:      2,
+		router:    httprouter.New(),
+		repo:      app.Repo,
+		binn:      app.Binary,
+		name:      app.Name,
+		branch:    app.Branch,
+		ports:     app.Ports,
+		hosts:     app.Hosts,
+		statePath: app.State,
+		logs:      app.Logs,
+		redeploy:  app.Redeploy,
+		restart:   app.Restart,
+		queue:     newDeployQueue(),
+		live:      newLiveLog(),
+		events:    newEventBus(),
+		output:    os.Stdout,
+		phase:     phase{state: phaseIdle, since: time.Now()},
+		bans:      newBanList(config().BanThreshold, config().BanDuration),
+		pushLimit: newRateLimiter(config().PushRate),
+		metrics:   newMetricSet(),
+	}
+}
+
+// ServeHTTTP handler
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.router.ServeHTTP(w, r)
+}
+
+func (m *Manager) clearPrevious() error {
+	for _, d := range m.history {
+		err := os.RemoveAll(d.dir)
+
+		if err != nil {
+			return errors.Wrap(err, "removing build directory")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package deployer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/romanyx/watcher/proxy"
+)
+
+// buildBuckets and requestBuckets are the histogram buckets in seconds of
+// build durations and proxied request latencies.
+var (
+	buildBuckets   = []float64{10, 30, 60, 120, 300, 600, 1200}
+	requestBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// histogram counts observations into cumulative buckets the way
+// Prometheus expects them.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, b, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// metricSet holds the counters of an app exposed on -metrics-path.
+type metricSet struct {
+	mu sync.Mutex
+
+	deploys         map[string]uint64
+	builds          *histogram
+	restarts        uint64
+	webhookFailures uint64
+	requests        map[int]*histogram
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{
+		deploys:  map[string]uint64{"success": 0, "failure": 0},
+		builds:   newHistogram(buildBuckets),
+		requests: map[int]*histogram{},
+	}
+}
+
+func (m *metricSet) deploy(ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ok {
+		m.deploys["success"]++
+	} else {
+		m.deploys["failure"]++
+	}
+}
+
+func (m *metricSet) build(d time.Duration) {
+	m.mu.Lock()
+	m.builds.observe(d.Seconds())
+	m.mu.Unlock()
+}
+
+func (m *metricSet) restart() {
+	m.mu.Lock()
+	m.restarts++
+	m.mu.Unlock()
+}
+
+func (m *metricSet) webhookFailure() {
+	m.mu.Lock()
+	m.webhookFailures++
+	m.mu.Unlock()
+}
+
+func (m *metricSet) request(status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := m.requests[status]
+	if h == nil {
+		h = newHistogram(requestBuckets)
+		m.requests[status] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// write writes m in the Prometheus text format, side is the active side.
+func (m *metricSet) write(w io.Writer, side int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP watcher_deploys_total Deployments by result.")
+	fmt.Fprintln(w, "# TYPE watcher_deploys_total counter")
+	for _, result := range []string{"success", "failure"} {
+		fmt.Fprintf(w, "watcher_deploys_total{result=%q} %d\n", result, m.deploys[result])
+	}
+
+	fmt.Fprintln(w, "# HELP watcher_build_duration_seconds Duration of completed builds.")
+	fmt.Fprintln(w, "# TYPE watcher_build_duration_seconds histogram")
+	m.builds.write(w, "watcher_build_duration_seconds", "")
+
+	fmt.Fprintln(w, "# HELP watcher_active_side Side of the instance serving traffic.")
+	fmt.Fprintln(w, "# TYPE watcher_active_side gauge")
+	fmt.Fprintf(w, "watcher_active_side %d\n", side)
+
+	fmt.Fprintln(w, "# HELP watcher_instance_restarts_total Restarts of crashed instances.")
+	fmt.Fprintln(w, "# TYPE watcher_instance_restarts_total counter")
+	fmt.Fprintf(w, "watcher_instance_restarts_total %d\n", m.restarts)
+
+	fmt.Fprintln(w, "# HELP watcher_webhook_verification_failures_total Pushes with a wrong signature.")
+	fmt.Fprintln(w, "# TYPE watcher_webhook_verification_failures_total counter")
+	fmt.Fprintf(w, "watcher_webhook_verification_failures_total %d\n", m.webhookFailures)
+
+	fmt.Fprintln(w, "# HELP watcher_requests_duration_seconds Latency of proxied requests by status code.")
+	fmt.Fprintln(w, "# TYPE watcher_requests_duration_seconds histogram")
+	codes := make([]int, 0, len(m.requests))
+	for code := range m.requests {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		m.requests[code].write(w, "watcher_requests_duration_seconds", fmt.Sprintf("code=\"%d\"", code))
+	}
+}
+
+// instrument records the status and latency of requests served by h in
+// metrics.
+func instrument(metrics *metricSet, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &proxy.StatusWriter{ResponseWriter: w}
+
+		h.ServeHTTP(sw, r)
+
+		status := sw.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.request(status, time.Since(start))
+	})
+}
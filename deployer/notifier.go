@@ -0,0 +1,166 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Deployment events. Chat notifiers get the ones from eventStarted on,
+// event hooks and /_events all of them.
+const (
+	eventReceived = "received"
+	eventBuilding = "building"
+	eventCloning  = "cloning"
+	eventBuilt    = "built"
+	eventHealthy  = "healthy"
+	eventSwitched = "switched"
+
+	eventStarted    = "started"
+	eventSucceeded  = "succeeded"
+	eventFailed     = "failed"
+	eventRolledBack = "rolled back"
+	eventCrashing   = "keeps crashing"
+)
+
+// deployEvent is one notification about a deployment.
+type deployEvent struct {
+	kind     string
+	head     string
+	from     string
+	trigger  string
+	duration time.Duration
+	err      string
+
+	// output is the end of the output of a failed build.
+	output string
+}
+
+// notifying reports whether any chat notifier is configured.
+func notifying() bool {
+	return config().SlackWebhook != "" || config().DiscordWebhook != "" || (config().TelegramToken != "" && config().TelegramChat != "")
+}
+
+// notify publishes ev to /_events and posts it to the event hooks and the
+// configured chat notifiers in the background. Failures are mailed too.
+func (m *Manager) notify(ev deployEvent) {
+	m.events.publish(m.payload(ev))
+	m.postHooks(ev)
+	m.informPlugins(ev)
+
+	switch ev.kind {
+	case eventReceived, eventBuilding, eventCloning, eventBuilt, eventHealthy, eventSwitched:
+		return
+	}
+
+	if mailing() && (ev.kind == eventFailed || ev.kind == eventCrashing) {
+		go func() {
+			if err := m.mailEvent(ev); err != nil {
+				logger(subWatcher).Warn("Failure mail failed", "err", err)
+			}
+		}()
+	}
+
+	if !notifying() {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		text := m.eventText(ctx, ev)
+
+		if config().SlackWebhook != "" {
+			if err := postJSON(ctx, config().SlackWebhook, map[string]string{"text": text}); err != nil {
+				logger(subWatcher).Warn("Slack notification failed", "err", err)
+			}
+		}
+
+		if config().DiscordWebhook != "" {
+			if err := postJSON(ctx, config().DiscordWebhook, map[string]string{"content": text}); err != nil {
+				logger(subWatcher).Warn("Discord notification failed", "err", err)
+			}
+		}
+
+		if config().TelegramToken != "" && config().TelegramChat != "" {
+			u := "https://api.telegram.org/bot" + config().TelegramToken + "/sendMessage"
+			if err := postJSON(ctx, u, map[string]string{"chat_id": config().TelegramChat, "text": text}); err != nil {
+				// The URL holds the token, keep it out of the log.
+				var uerr *url.Error
+				if errors.As(err, &uerr) {
+					err = uerr.Err
+				}
+				logger(subWatcher).Warn("Telegram notification failed", "err", err)
+			}
+		}
+	}()
+}
+
+// eventText formats ev as a chat message.
+func (m *Manager) eventText(ctx context.Context, ev deployEvent) string {
+	text := fmt.Sprintf("Deploy of %s@%s %s", m.repo, shortSha(ev.head), ev.kind)
+	if ev.kind == eventRolledBack {
+		text = fmt.Sprintf("%s rolled back from %s to %s", m.repo, shortSha(ev.from), shortSha(ev.head))
+	}
+
+	if ev.duration > 0 {
+		text += fmt.Sprintf(" in %s", ev.duration.Round(time.Second))
+	}
+
+	if msg, err := commitMessage(ctx, m.repo, ev.head); err == nil {
+		text += "\n" + msg
+	}
+
+	if ev.err != "" {
+		text += "\nError: " + ev.err
+	}
+
+	if config().PublicURL != "" {
+		text += "\nLogs: " + config().PublicURL + "/_logs/" + url.PathEscape(ev.head)
+	}
+
+	return text
+}
+
+// shortSha abbreviates a commit hash the way git does.
+func shortSha(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+
+	return sha
+}
+
+// postJSON posts v as JSON to u.
+func postJSON(ctx context.Context, u string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "marshal json")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "post request")
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("post request %v", resp.Status)
+	}
+
+	return nil
+}
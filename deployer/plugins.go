@@ -0,0 +1,120 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Plugin points which may veto a deployment, before its checkout and
+// before traffic is switched to its healthy instance.
+const (
+	pointPreBuild  = "pre_build"
+	pointPreSwitch = "pre_switch"
+)
+
+// pluginResult is what a plugin may print on stdout. Output which is not
+// such JSON is taken as the message.
+type pluginResult struct {
+	Message     string            `json:"message"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// runPlugin runs the -plugin command line with body on stdin. It fails
+// when the plugin exits non-zero, with its message or error output.
+func runPlugin(ctx context.Context, line string, body []byte) (pluginResult, error) {
+	var res pluginResult
+
+	args, err := splitArgs(line)
+	if err != nil || len(args) == 0 {
+		return res, errors.Errorf("plugin %q: invalid command", line)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config().PluginTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = childEnv()
+
+	runErr := cmd.Run()
+
+	if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+		if json.Unmarshal(out, &res) != nil {
+			res = pluginResult{Message: string(out)}
+		}
+	}
+
+	if runErr != nil {
+		msg := res.Message
+		if msg == "" {
+			msg = strings.TrimSpace(stderr.String())
+		}
+		if msg == "" {
+			msg = runErr.Error()
+		}
+
+		return res, errors.Errorf("plugin %s: %s", args[0], msg)
+	}
+
+	return res, nil
+}
+
+// consult runs the plugins at point for the head of a one after another.
+// Their annotations are added to a, the first veto is returned.
+func (m *Manager) consult(ctx context.Context, point string, a *attempt) error {
+	if len(config().Plugins) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(m.payload(deployEvent{kind: point, head: a.Head, trigger: a.Trigger}))
+	if err != nil {
+		return errors.Wrap(err, "marshal plugin event")
+	}
+
+	for _, line := range config().Plugins {
+		res, err := runPlugin(ctx, line, body)
+
+		for k, v := range res.Annotations {
+			if a.Annotations == nil {
+				a.Annotations = map[string]string{}
+			}
+			a.Annotations[k] = v
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// informPlugins runs the plugins for ev in the background, they can't
+// veto it.
+func (m *Manager) informPlugins(ev deployEvent) {
+	if len(config().Plugins) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(m.payload(ev))
+	if err != nil {
+		logger(subWatcher).Error("Marshal plugin event failed", "err", err)
+		return
+	}
+
+	for _, line := range config().Plugins {
+		go func(line string) {
+			if _, err := runPlugin(context.Background(), line, body); err != nil {
+				logger(subWatcher).Warn("Plugin failed", "event", hookEvent(ev.kind), "err", err)
+			}
+		}(line)
+	}
+}
@@ -0,0 +1,61 @@
+package deployer
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pollLoop queues the head of the branch of m every -poll when it moved
+// from deployed, the head deployed at start, and from the head pushed
+// last. It runs alongside the webhook and must be started once.
+func (m *Manager) pollLoop(deployed string) {
+	seen := deployed
+
+	for {
+		interval := config().Poll
+		if interval <= 0 {
+			// -poll may be set by a reload later on.
+			interval = time.Minute
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-deployCtx.Done():
+			return
+		}
+
+		if config().Poll <= 0 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(deployCtx, time.Minute)
+		sha, err := m.getCurrent(ctx)
+		cancel()
+
+		var limited errRateLimited
+		if errors.As(err, &limited) {
+			logger(subWebhook).Warn("Polling rate limited", "app", m.name, "until", limited.until)
+			select {
+			case <-time.After(time.Until(limited.until)):
+			case <-deployCtx.Done():
+				return
+			}
+			continue
+		}
+		if err != nil {
+			logger(subWebhook).Error("Poll failed", "app", m.name, "err", err)
+			continue
+		}
+
+		if sha == "" || sha == seen || sha == m.queue.latest() {
+			continue
+		}
+		seen = sha
+
+		logger(subWebhook).Info("Polled new head", "app", m.name, "sha", sha)
+		m.queue.push(sha, triggerPoll)
+		m.notify(deployEvent{kind: eventReceived, head: sha, trigger: triggerPoll})
+	}
+}
@@ -0,0 +1,140 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var errNoFreePort = errors.New("no free port in range")
+
+// parsePorts parses the -ports flag: a single port, a lo-hi range or 0 for
+// any free port.
+func parsePorts(s string) (lo, hi int, err error) {
+	parts := strings.SplitN(s, "-", 2)
+
+	lo, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "parse ports")
+	}
+
+	hi = lo
+	if len(parts) == 2 {
+		hi, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "parse ports")
+		}
+	}
+
+	if lo < 0 || hi > 65535 || lo > hi {
+		return 0, 0, errors.Errorf("invalid port range %q", s)
+	}
+
+	return lo, hi, nil
+}
+
+// checkPorts parses the port range s and makes sure it fits -replicas
+// instances of both sides, which run at once during a switch.
+func checkPorts(s string) error {
+	lo, hi, err := parsePorts(s)
+	if err != nil {
+		return err
+	}
+
+	if lo == 0 || config().Socket {
+		return nil
+	}
+	if need := 2 * config().Replicas; hi-lo+1 < need {
+		return errors.Errorf("port range %q has room for %d instances, -replicas=%d needs %d", s, hi-lo+1, config().Replicas, need)
+	}
+
+	return nil
+}
+
+// allocPort returns a free port for a new instance which is not used by
+// any running one.
+func (m *Manager) allocPort() (int, error) {
+	lo, hi, err := parsePorts(m.ports)
+	if err != nil {
+		return 0, err
+	}
+
+	if lo == 0 {
+		l, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			return 0, errors.Wrap(err, "listen on free port")
+		}
+		defer l.Close()
+
+		return l.Addr().(*net.TCPAddr).Port, nil
+	}
+
+	// The ports of exited replicas are free for their restart.
+	var sides []*backend
+	if m.backend != nil {
+		sides = append(sides, m.backend)
+	}
+	if m.canary != nil {
+		sides = append(sides, m.canary.backend)
+	}
+	if m.staged != nil {
+		sides = append(sides, m.staged.backend)
+	}
+
+	used := make(map[int]bool)
+	for _, b := range sides {
+		for _, r := range b.replicas() {
+			if r.alive() {
+				used[r.port] = true
+			}
+		}
+	}
+
+	for port := lo; port <= hi; port++ {
+		if used[port] {
+			continue
+		}
+
+		l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+		if err != nil {
+			continue
+		}
+		l.Close()
+
+		return port, nil
+	}
+
+	return 0, errors.Wrap(errNoFreePort, m.ports)
+}
+
+// waitListening waits until the process of b accepts connections.
+func waitListening(ctx context.Context, b *backend) error {
+	ctx, cancel := context.WithTimeout(ctx, config().HealthTimeout)
+	defer cancel()
+
+	addr := b.addr
+
+	for {
+		conn, err := net.DialTimeout(b.network, addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+
+		select {
+		case <-b.done:
+			if b.err == nil {
+				return errors.New("process exited before listening")
+			}
+			return errors.Wrap(b.err, "process exited before listening")
+		case <-ctx.Done():
+			return errors.Wrapf(err, "not listening on %s after %s", addr, config().HealthTimeout)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+
+package deployer
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolateInstance starts cmd of an instance in its own process group, so a
+// Ctrl-C sent to the watcher's group doesn't reach it and instances left
+// running by -shutdown survive.
+func isolateInstance(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminate asks process to exit.
+func terminate(process *os.Process) error {
+	return process.Signal(syscall.SIGTERM)
+}
+
+// processAlive reports whether the process pid is running.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// freeSpace returns the bytes available to unprivileged users on the file
+// system of dir.
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,242 @@
+package deployer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Causes of cancelled deployments.
+var (
+	errSuperseded = errors.New("superseded by a newer push")
+	errShutdown   = errors.New("watcher shutting down")
+)
+
+// deployCtx is the context of all deployments and restarts of instances,
+// cancelled with errShutdown when the watcher shuts down. deploying counts
+// the deployments running.
+var (
+	deployCtx, stopDeploys = context.WithCancelCause(context.Background())
+	deploying              sync.WaitGroup
+)
+
+// deployQueue feeds heads to a single deploy worker. Only the newest head is
+// kept: pushes arriving while a build is running replace any waiting head and
+// cancel the build in progress, so intermediate commits are never deployed.
+// A push of the head being built leaves the build alone.
+//
+// Queued heads are held while deployments are paused or outside of the
+// deploy window.
+type deployQueue struct {
+	mu      sync.Mutex
+	next    string
+	trigger string
+	newest  string
+	current string
+	cancel  context.CancelCauseFunc
+	wake    chan struct{}
+
+	paused bool
+	window *schedule
+}
+
+func newDeployQueue() *deployQueue {
+	return &deployQueue{wake: make(chan struct{}, 1)}
+}
+
+// push schedules head for deployment, superseding whatever is queued or
+// being built, unless head is being built already. trigger tells what
+// asked for it.
+func (q *deployQueue) push(head, trigger string) {
+	q.mu.Lock()
+	q.newest = head
+	if q.cancel != nil && head == q.current && q.next == "" {
+		q.mu.Unlock()
+		return
+	}
+
+	q.next, q.trigger = head, trigger
+	if q.cancel != nil {
+		q.cancel(errSuperseded)
+	}
+	q.mu.Unlock()
+
+	q.poke()
+}
+
+// poke wakes the worker up to look at the queue again.
+func (q *deployQueue) poke() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pause holds queued heads until resume.
+func (q *deployQueue) pause() {
+	q.mu.Lock()
+	q.paused = true
+	q.mu.Unlock()
+}
+
+// resume lets held heads be deployed.
+func (q *deployQueue) resume() {
+	q.mu.Lock()
+	q.paused = false
+	q.mu.Unlock()
+
+	q.poke()
+}
+
+// held reports whether deployments are paused or outside of the window at t.
+func (q *deployQueue) held(t time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.paused || (q.window != nil && !q.window.match(t))
+}
+
+// pending returns the head waiting to be deployed, if any.
+func (q *deployQueue) pending() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.next
+}
+
+// latest returns the head pushed last, queued or not.
+func (q *deployQueue) latest() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.newest
+}
+
+// take pops the queued head with its trigger and returns a context which
+// is cancelled when a newer head is pushed.
+func (q *deployQueue) take() (string, string, context.Context, context.CancelFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	head, trigger := q.next, q.trigger
+	q.next = ""
+
+	ctx, cancel := context.WithCancelCause(deployCtx)
+	q.cancel = cancel
+	q.current = head
+
+	return head, trigger, ctx, func() {
+		q.mu.Lock()
+		q.cancel, q.current = nil, ""
+		q.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// deployLoop is the single deploy worker. It must be started once.
+func (m *Manager) deployLoop() {
+	// A deploy window may be set by a reload later on.
+	go func() {
+		for range time.Tick(time.Minute) {
+			m.queue.poke()
+		}
+	}()
+
+	for range m.queue.wake {
+		if m.queue.held(time.Now()) {
+			continue
+		}
+
+		if deployCtx.Err() != nil {
+			return
+		}
+
+		head, trigger, ctx, done := m.queue.take()
+		if head != "" {
+			deploying.Add(1)
+			m.deploy(ctx, head, trigger, true, config().DryRun)
+			deploying.Done()
+		}
+		done()
+	}
+}
+
+// deploy runs and records one attempt to deploy head, waiting for CI first
+// when ci is set. A dry run builds and checks head without switching
+// traffic and is not announced.
+func (m *Manager) deploy(ctx context.Context, head, trigger string, ci, dry bool) *attempt {
+	ctx, sp := startSpan(ctx, "deploy")
+	sp.set("vcs.revision", head)
+	sp.set("deploy.trigger", trigger)
+
+	a := m.journal.begin(head, trigger)
+	a.DryRun = dry
+	if !dry {
+		m.notify(deployEvent{kind: eventStarted, head: head, trigger: trigger})
+	}
+
+	if (!ci || m.checkCI(ctx, a)) && m.takeTurn(ctx, a) {
+		if err := m.consult(ctx, pointPreBuild, a); err != nil {
+			logger(subBuilder).Warn("Deploy vetoed", "sha", head, "err", err)
+			a.Error = err.Error()
+		} else {
+			m.changeSide(ctx, a)
+		}
+		m.endTurn(a)
+	}
+	m.journal.record(a)
+
+	switch {
+	case dry:
+	case a.Result == resultSuccess:
+		m.notify(deployEvent{kind: eventSucceeded, head: head, trigger: trigger, duration: a.Ended.Sub(a.Started)})
+	case a.Result == resultFailure:
+		m.notify(deployEvent{kind: eventFailed, head: head, trigger: trigger, duration: a.Ended.Sub(a.Started), err: a.Error, output: a.Output})
+	}
+
+	sp.set("deploy.result", a.Result)
+
+	var err error
+	if a.Result == resultFailure {
+		err = errors.New(a.Error)
+	}
+	sp.finish(err)
+
+	return a
+}
+
+// checkCI reports whether the head of a may be deployed as far as CI is
+// concerned.
+func (m *Manager) checkCI(ctx context.Context, a *attempt) bool {
+	if !config().WaitCI {
+		return true
+	}
+
+	head := a.Head
+	start := time.Now()
+	ciCtx, sp := startSpan(ctx, "ci")
+	err := waitForCI(ciCtx, m.repo, head)
+	sp.finish(err)
+	a.stage("ci", start)
+	if err == nil {
+		return true
+	}
+
+	if ctx.Err() == context.Canceled {
+		logger(subBuilder).Info("Waiting for CI cancelled", "sha", head, "cause", context.Cause(ctx))
+		a.Result = resultCancelled
+		return false
+	}
+
+	a.Error = err.Error()
+
+	logger(subBuilder).Error("Wait for CI failed", "sha", head, "err", err)
+
+	m.mu.Lock()
+	m.failure = err.Error()
+	m.unlock()
+
+	return false
+}
@@ -0,0 +1,103 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/romanyx/watcher/builder"
+)
+
+// remoteData is available to the -ssh-start and -ssh-stop templates.
+type remoteData struct {
+	Binary string
+	Port   int
+	Dir    string
+	Sha    string
+	Host   string
+}
+
+// remoteHosts returns the hosts of -ssh-hosts.
+func remoteHosts() []string {
+	var hosts []string
+	for _, h := range strings.Split(config().SSHHosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+
+	return hosts
+}
+
+// sshStep returns the step running the shell command line on host.
+func sshStep(host, line string) builder.Step {
+	step := builder.Step{"ssh", "-o", "BatchMode=yes"}
+	if config().SSHIdentity != "" {
+		step = append(step, "-i", config().SSHIdentity)
+	}
+
+	return append(step, host, line)
+}
+
+// scpStep returns the step copying the local file src to dst on host.
+func scpStep(host, src, dst string) builder.Step {
+	step := builder.Step{"scp", "-q", "-o", "BatchMode=yes"}
+	if config().SSHIdentity != "" {
+		step = append(step, "-i", config().SSHIdentity)
+	}
+
+	return append(step, src, host+":"+dst)
+}
+
+// deployRemote copies the build d to host, replaces the instance running
+// there with -ssh-stop and -ssh-start and waits until it is healthy.
+func (m *Manager) deployRemote(ctx context.Context, host string, d *deployment, out io.Writer) error {
+	data := remoteData{
+		Binary: builder.ExeName(m.binn),
+		Port:   config().SSHPort,
+		Dir:    path.Join(config().SSHDir, d.head),
+		Sha:    d.head,
+		Host:   host,
+	}
+
+	stop, err := render("ssh-stop", config().SSHStop, data)
+	if err != nil {
+		return err
+	}
+
+	start, err := render("ssh-start", config().SSHStart, data)
+	if err != nil {
+		return err
+	}
+
+	steps := []builder.Step{
+		sshStep(host, "mkdir -p "+data.Dir),
+		scpStep(host, filepath.Join(d.dir, data.Binary), data.Dir+"/"+data.Binary),
+		sshStep(host, stop),
+		sshStep(host, start),
+	}
+	for _, step := range steps {
+		if err := builder.Run(ctx, d.dir, stepEnv(), out, step); err != nil {
+			return err
+		}
+	}
+
+	if config().HealthPath == "" {
+		return nil
+	}
+
+	name := host
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	client := &http.Client{Timeout: config().HealthInterval}
+	base := fmt.Sprintf("http://%s:%d", name, config().SSHPort)
+
+	return errors.Wrap(probeHealthy(ctx, client, base, nil, nil), "health check")
+}
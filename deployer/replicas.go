@@ -0,0 +1,148 @@
+package deployer
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicas returns b and the other instances of its side.
+func (b *backend) replicas() []*backend {
+	return append([]*backend{b}, b.peerList()...)
+}
+
+// peerList returns a copy of the peers of b.
+func (b *backend) peerList() []*backend {
+	b.peerMu.Lock()
+	defer b.peerMu.Unlock()
+
+	return append([]*backend(nil), b.peers...)
+}
+
+// replacePeer puts peer in place of the crashed old. It reports false when
+// b is being stopped, the caller has to stop peer then.
+func (b *backend) replacePeer(old, peer *backend) bool {
+	b.peerMu.Lock()
+	defer b.peerMu.Unlock()
+
+	if atomic.LoadInt32(&b.stopping) == 1 {
+		return false
+	}
+
+	for i, r := range b.peers {
+		if r == old {
+			b.peers[i] = peer
+			return true
+		}
+	}
+
+	return false
+}
+
+// healthy returns the replicas of b which are running and passed their
+// last health probe.
+func (b *backend) healthy() []*backend {
+	var up []*backend
+	for _, r := range b.replicas() {
+		if r.alive() && atomic.LoadInt32(&r.sick) == 0 {
+			up = append(up, r)
+		}
+	}
+
+	return up
+}
+
+// pick returns the healthy replica of b next in turn, b itself when there
+// is none.
+func (b *backend) pick() *backend {
+	if len(b.peerList()) == 0 {
+		return b
+	}
+
+	up := b.healthy()
+	if len(up) == 0 {
+		return b
+	}
+
+	return up[atomic.AddUint64(&b.turn, 1)%uint64(len(up))]
+}
+
+// kill kills the processes of all replicas of b.
+func (b *backend) kill() {
+	for _, r := range b.replicas() {
+		r.process.Kill()
+	}
+}
+
+// stopPeers stops the peers of b like stop, all at once.
+func (b *backend) stopPeers(grace time.Duration) error {
+	peers := b.peerList()
+	errs := make([]error, len(peers))
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer *backend) {
+			defer wg.Done()
+			errs[i] = peer.stopOne(grace)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkReplicas probes the replicas of b at -health-path every
+// -health-interval and takes the ones failing out of rotation until they
+// pass again. It returns once b exits or is stopped.
+func (b *backend) checkReplicas() {
+	if config().HealthPath == "" || len(b.peerList()) == 0 {
+		return
+	}
+
+	l := logger(subSupervisor).With("sha", b.head)
+
+	for {
+		select {
+		case <-time.After(config().HealthInterval):
+		case <-b.done:
+			return
+		}
+
+		if atomic.LoadInt32(&b.stopping) == 1 {
+			return
+		}
+
+		for _, r := range b.replicas() {
+			if !r.alive() {
+				continue
+			}
+
+			client := &http.Client{Transport: r.transport, Timeout: config().HealthInterval}
+			err := probe(context.Background(), client, strings.TrimSuffix(r.base.String(), "/")+config().HealthPath)
+
+			var sick int32
+			if err != nil {
+				sick = 1
+			}
+			if atomic.SwapInt32(&r.sick, sick) == sick {
+				continue
+			}
+
+			if err != nil {
+				l.Warn("Replica out of rotation", "addr", r.addr, "err", err)
+			} else {
+				l.Info("Replica back in rotation", "addr", r.addr)
+			}
+		}
+	}
+}
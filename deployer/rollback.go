@@ -0,0 +1,313 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/romanyx/watcher/builder"
+)
+
+var (
+	errNoPrevious  = errors.New("no previous deployment to roll back to")
+	errNotRetained = errors.New("build is not retained")
+)
+
+// deployment is a build of head living in dir. image is the digest of
+// its pushed image.
+type deployment struct {
+	head  string
+	dir   string
+	built time.Time
+	image string
+}
+
+// otherSide returns the side which is not serving traffic.
+func (m *Manager) otherSide() int {
+	if m.side == 1 {
+		return 2
+	}
+
+	return 1
+}
+
+// remember puts d in front of the history and removes the builds beyond
+// -retain from disk.
+func (m *Manager) remember(d *deployment) {
+	history := []*deployment{d}
+	for _, h := range m.history {
+		if h != d {
+			history = append(history, h)
+		}
+	}
+
+	keep := config().Retain
+	if keep < 1 {
+		keep = 1
+	}
+
+	for len(history) > keep {
+		old := history[len(history)-1]
+		history = history[:len(history)-1]
+
+		if err := os.RemoveAll(old.dir); err != nil {
+			logger(subBuilder).Error("Remove expired build failed", "sha", old.head, "err", err)
+		}
+	}
+
+	m.history = history
+}
+
+// launch starts -replicas instances of the build d as side and waits
+// until they are healthy. The first one leads the others.
+func (m *Manager) launch(ctx context.Context, side int, d *deployment) (*backend, error) {
+	b, err := m.launchOne(ctx, side, d, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; i < config().Replicas; i++ {
+		peer, err := m.launchOne(ctx, side, d, i)
+		if err != nil {
+			b.kill()
+			return nil, errors.Wrapf(err, "replica %d", i)
+		}
+		b.peers = append(b.peers, peer)
+	}
+
+	for i, peer := range b.peers {
+		go m.supervisePeer(b, peer, d, side, i+1)
+	}
+	go b.checkReplicas()
+
+	return b, nil
+}
+
+// launchOne starts replica of the build d as side on a free port, or a
+// socket in its directory with -socket, and waits until it is healthy.
+func (m *Manager) launchOne(ctx context.Context, side int, d *deployment, replica int) (*backend, error) {
+	var (
+		network, addr string
+		port          int
+		socket        string
+	)
+
+	if config().Socket {
+		socket = filepath.Join(d.dir, m.binn+".sock")
+		if replica > 0 {
+			socket = filepath.Join(d.dir, fmt.Sprintf("%s-%d.sock", m.binn, replica))
+		}
+		os.Remove(socket)
+		network, addr = "unix", socket
+	} else {
+		var err error
+		if port, err = m.allocPort(); err != nil {
+			return nil, err
+		}
+		network, addr = "tcp", fmt.Sprintf("localhost:%d", port)
+	}
+
+	runLog, err := m.openLog(d.head, "run")
+	if err != nil {
+		return nil, err
+	}
+
+	runCmd, err := runCommand(runData{
+		Binary:  builder.ExeName(m.binn),
+		Port:    port,
+		Socket:  socket,
+		Side:    side,
+		Replica: replica,
+		Dir:     d.dir,
+		Sha:     d.head,
+	}, io.MultiWriter(runLog, m.live.writer(d.head)))
+	if err != nil {
+		runLog.Close()
+		return nil, err
+	}
+
+	limits, err := config().parseLimits(config().RunLimits)
+	if err != nil {
+		runLog.Close()
+		return nil, errors.Wrap(err, "invalid -run-limits")
+	}
+
+	b, err := startBackend(runCmd, limits, network, addr)
+	if err != nil {
+		runLog.Close()
+		return nil, err
+	}
+	b.head = d.head
+	go func() {
+		<-b.done
+		runLog.Close()
+	}()
+
+	b.retry = m.retryFor(b)
+
+	if err := waitListening(ctx, b); err != nil {
+		b.process.Kill()
+		return nil, err
+	}
+
+	healthCtx, sp := startSpan(ctx, "health check")
+	err = waitHealthy(healthCtx, b)
+	sp.finish(err)
+	if err != nil {
+		b.process.Kill()
+		return nil, errors.Wrap(err, "health check")
+	}
+
+	return b, nil
+}
+
+// switchTo points traffic at b and stops the backend serving before.
+func (m *Manager) switchTo(b *backend) error {
+	last := m.backend
+	m.backend = b
+	m.reroute()
+	go m.supervise(b)
+
+	if last != nil {
+		if err := last.stop(config().DrainGrace); err != nil {
+			return errors.Wrap(err, "stop previous command")
+		}
+	}
+
+	return nil
+}
+
+// restore switches traffic to the retained build d. The caller must hold
+// m.mu.
+func (m *Manager) restore(ctx context.Context, d *deployment) error {
+	if config().PushOnly {
+		return errors.New("nothing runs with -push-only")
+	}
+
+	if kubeEnabled() {
+		if err := m.rollOut(ctx, d.head, m.output); err != nil {
+			return errors.Wrapf(err, "roll out %s", d.head)
+		}
+	} else {
+		m.dropCandidates()
+
+		side := m.otherSide()
+
+		b, err := m.launch(ctx, side, d)
+		if err != nil {
+			return errors.Wrapf(err, "launch %s", d.head)
+		}
+
+		if err := m.switchTo(b); err != nil {
+			logger(subSupervisor).Error("Switch failed", "sha", d.head, "side", side, "err", err)
+		}
+
+		m.side = side
+	}
+
+	m.remember(d)
+
+	m.dir = d.dir
+	m.last = d.head
+	m.failure = ""
+	m.consecutive = 0
+	m.saveState()
+
+	return nil
+}
+
+// ready reports whether an instance is serving traffic.
+func (m *Manager) ready() bool {
+	b := m.route().backend
+	return b != nil && b.alive()
+}
+
+// stop stops the serving instance and the candidates.
+func (m *Manager) stop() {
+	m.mu.Lock()
+	defer m.unlock()
+
+	m.dropCandidates()
+
+	if m.backend != nil {
+		if err := m.backend.stop(config().DrainGrace); err != nil {
+			m.logger(subSupervisor).Error("Stop instance failed", "err", err)
+		}
+	}
+}
+
+// rollback switches traffic back to the build deployed before the current
+// one and marks the current head as rolled back. It returns the head now
+// serving.
+func (m *Manager) rollback(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	if len(m.history) < 2 {
+		return "", errNoPrevious
+	}
+
+	bad := m.last
+	if err := m.rollBack(ctx); err != nil {
+		return "", err
+	}
+
+	m.logger(subSupervisor).Info("Rolled back", "from", bad)
+	m.notify(deployEvent{kind: eventRolledBack, head: m.last, from: bad})
+
+	return m.last, nil
+}
+
+// rollBack restores the build deployed before the current one and drops
+// the current one from the history, so a further rollback goes back further
+// instead of returning to it. The caller must hold m.mu and make sure there
+// is a build to roll back to.
+func (m *Manager) rollBack(ctx context.Context) error {
+	bad := m.history[0]
+	if err := m.restore(ctx, m.history[1]); err != nil {
+		return err
+	}
+	m.rolledBack = bad.head
+
+	var history []*deployment
+	for _, d := range m.history {
+		if d != bad {
+			history = append(history, d)
+		}
+	}
+	m.history = history
+	m.saveState()
+
+	if err := os.RemoveAll(bad.dir); err != nil {
+		logger(subBuilder).Error("Remove rolled back build failed", "sha", bad.head, "err", err)
+	}
+
+	return nil
+}
+
+// restoreRetained switches traffic to the retained build of head.
+func (m *Manager) restoreRetained(ctx context.Context, head string) error {
+	m.mu.Lock()
+	defer m.unlock()
+
+	if head == m.last {
+		return nil
+	}
+
+	for _, d := range m.history {
+		if d.head == head {
+			if err := m.restore(ctx, d); err != nil {
+				return err
+			}
+
+			m.logger(subSupervisor).Info("Switched to retained build")
+			return nil
+		}
+	}
+
+	return errors.Wrap(errNotRetained, head)
+}
@@ -0,0 +1,117 @@
+package deployer
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// runData is available to the -run and -run-dir templates.
+type runData struct {
+	Binary string
+	Port   int
+	Socket string
+	Side   int
+	Dir    string
+	Sha    string
+
+	// Replica numbers the instances of a side from 0 with -replicas.
+	Replica int
+}
+
+// runCommand renders -run and -run-dir for data into the command starting
+// an instance which writes its output to out. The port is passed in the
+// -port-env variable too.
+func runCommand(data runData, out io.Writer) (*exec.Cmd, error) {
+	line, err := render("run", config().Run, data)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := splitArgs(line)
+	if err != nil {
+		return nil, errors.Wrap(err, "split run command")
+	}
+
+	if len(args) == 0 {
+		return nil, errors.New("empty run command")
+	}
+
+	dir, err := render("run-dir", config().RunDir, data)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.Dir = dir
+	cmd.Env = childEnv()
+	if config().PortEnv != "" && data.Port != 0 {
+		cmd.Env = append(cmd.Env, config().PortEnv+"="+strconv.Itoa(data.Port))
+	}
+	isolateInstance(cmd)
+
+	return cmd, nil
+}
+
+func render(name, text string, data interface{}) (string, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse %s template", name)
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", errors.Wrapf(err, "execute %s template", name)
+	}
+
+	return buf.String(), nil
+}
+
+// splitArgs splits s into arguments on spaces. Single and double quotes
+// group arguments containing spaces.
+func splitArgs(s string) ([]string, error) {
+	var (
+		args  []string
+		arg   strings.Builder
+		quote rune
+		inArg bool
+	)
+
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.Errorf("unterminated quote in %q", s)
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
@@ -0,0 +1,82 @@
+package deployer
+
+import (
+	"time"
+)
+
+// scheduleLoop redeploys the current head and restarts its instance at the
+// minutes of the redeploy and restart schedules of m. It must be started
+// once.
+func (m *Manager) scheduleLoop() {
+	for {
+		now := time.Now()
+		select {
+		case <-time.After(now.Truncate(time.Minute).Add(time.Minute).Sub(now)):
+		case <-deployCtx.Done():
+			return
+		}
+
+		now = time.Now()
+		if s := m.cronFor(m.redeploy, config().RedeploySchedule); s != nil && s.match(now) {
+			m.scheduledRedeploy()
+		}
+		if s := m.cronFor(m.restart, config().RestartSchedule); s != nil && s.match(now) {
+			m.scheduledRestart()
+		}
+	}
+}
+
+// cronFor returns the schedule of own, or of fallback when own is empty.
+// It is nil when neither is set or valid.
+func (m *Manager) cronFor(own, fallback string) *schedule {
+	expr := own
+	if expr == "" {
+		expr = fallback
+	}
+	if expr == "" {
+		return nil
+	}
+
+	s, err := parseCron(expr)
+	if err != nil {
+		m.logger(subWatcher).Error("Invalid schedule", "cron", expr, "err", err)
+		return nil
+	}
+
+	return s
+}
+
+// scheduledRedeploy queues a fresh build of the current head, picking up
+// rebuilt base images or data baked in at build time.
+func (m *Manager) scheduledRedeploy() {
+	m.mu.Lock()
+	head := m.last
+	m.unlock()
+
+	if head == "" {
+		return
+	}
+
+	m.logger(subWatcher).Info("Scheduled redeploy", "sha", head)
+	m.queue.push(head, triggerCron)
+	m.notify(deployEvent{kind: eventReceived, head: head, trigger: triggerCron})
+}
+
+// scheduledRestart restarts the instance of the current build on the other
+// side, switching traffic over once it is healthy.
+func (m *Manager) scheduledRestart() {
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.backend == nil || len(m.history) == 0 {
+		m.logger(subSupervisor).Warn("Scheduled restart skipped, no instance runs here")
+		return
+	}
+
+	if err := m.relaunch(); err != nil {
+		m.logger(subSupervisor).Error("Scheduled restart failed", "err", err)
+		return
+	}
+
+	m.logger(subSupervisor).Info("Restarted on schedule", "sha", m.last)
+}
@@ -0,0 +1,227 @@
+package deployer
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Set holds the apps of a watcher and routes the requests of the front
+// listeners to them.
+type Set struct {
+	list   []*Manager
+	byHost map[string]*Manager
+
+	// Reload serves POST /_reload. It returns the changed settings which
+	// need a restart.
+	Reload func() ([]string, error)
+
+	// Notify gets the state of deployments for systemd, like
+	// "STATUS=Serving <sha>".
+	Notify func(state string)
+}
+
+// NewSet returns a set without apps running with the settings of c.
+func NewSet(c *Config) (*Set, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	current.Store(c)
+
+	return &Set{byHost: map[string]*Manager{}}, nil
+}
+
+// Add adds app to s.
+func (s *Set) Add(app App) error {
+	if err := checkPorts(app.Ports); err != nil {
+		return errors.Wrapf(err, "app %s", app.Name)
+	}
+
+	m := newManager(app)
+	m.set = s
+
+	j, err := openJournal(app.Journal)
+	if err != nil {
+		return errors.Wrapf(err, "app %s", app.Name)
+	}
+	m.journal = j
+
+	for _, h := range m.hosts {
+		if other, ok := s.byHost[h]; ok {
+			return errors.Errorf("host %s is served by both %s and %s", h, other.name, m.name)
+		}
+		s.byHost[h] = m
+	}
+	s.list = append(s.list, m)
+
+	return nil
+}
+
+// Start resumes every app from its state and mounts its endpoints, so s
+// can be served. With sweep build directories left behind by earlier runs
+// are removed first, an upgraded watcher's parent may still be using them.
+// Apps without a running instance are served the maintenance page until
+// Follow built them.
+func (s *Set) Start(sweep bool) error {
+	if coordinating() {
+		go leaseLoop()
+	}
+
+	c := config()
+	window, err := deployWindow(c)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range s.list {
+		m.queue.window = window
+
+		if err := m.loadState(); err != nil {
+			logger(subSupervisor).Warn("Load state failed", "app", m.name, "err", err)
+		}
+
+		if sweep {
+			m.sweepBuilds()
+		}
+
+		if err := m.mount(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Follow builds the head of every app unless it is serving it already,
+// then follows its branch. It returns early without an error once
+// StopDeploys was called.
+func (s *Set) Follow() error {
+	for _, m := range s.list {
+		err := m.firstBuild(deployCtx)
+		if deployCtx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "first build of %s", m.name)
+		}
+
+		go m.deployLoop()
+		go m.scheduleLoop()
+		if config().WatchDir != "" {
+			go m.watchLoop()
+		} else {
+			go m.pollLoop(m.last)
+		}
+	}
+
+	return nil
+}
+
+// mount registers the endpoints of m and the handlers around its app built
+// from c.
+func (m *Manager) mount(c *Config) error {
+	m.routes()
+
+	h, err := m.buildHandlers(c)
+	if err != nil {
+		return err
+	}
+	m.handlers.Store(h)
+
+	m.router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.handlers.Load().(*handlers).app.ServeHTTP(w, r)
+	})
+
+	return nil
+}
+
+// Configure switches s over to the settings of c. The handlers of all apps
+// are built first, so settings which don't work leave the ones in use
+// alone.
+func (s *Set) Configure(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	window, err := deployWindow(c)
+	if err != nil {
+		return err
+	}
+
+	hs := make([]*handlers, len(s.list))
+	for i, m := range s.list {
+		if hs[i], err = m.buildHandlers(c); err != nil {
+			return err
+		}
+	}
+
+	current.Store(c)
+
+	for i, m := range s.list {
+		m.queue.mu.Lock()
+		m.queue.window = window
+		m.queue.mu.Unlock()
+
+		m.handlers.Store(hs[i])
+	}
+
+	return nil
+}
+
+// Config returns the settings s runs with.
+func (s *Set) Config() *Config {
+	return config()
+}
+
+// deployWindow returns the -deploy-window of c, nil for none.
+func deployWindow(c *Config) (*schedule, error) {
+	if c.DeployWindow == "" {
+		return nil, nil
+	}
+
+	window, err := parseCron(c.DeployWindow)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid -deploy-window")
+	}
+
+	return window, nil
+}
+
+// Stop stops the instances of all apps at once, each within -drain-grace.
+func (s *Set) Stop() {
+	var wg sync.WaitGroup
+	for _, m := range s.list {
+		wg.Add(1)
+		go func(m *Manager) {
+			defer wg.Done()
+			m.stop()
+		}(m)
+	}
+	wg.Wait()
+}
+
+// ClearBuilds removes the retained builds of all apps.
+func (s *Set) ClearBuilds() error {
+	for _, m := range s.list {
+		if err := m.clearPrevious(); err != nil {
+			return errors.Wrapf(err, "app %s", m.name)
+		}
+	}
+
+	return nil
+}
+
+// notify passes state on to Notify.
+func (s *Set) notify(state string) {
+	if s.Notify != nil {
+		s.Notify(state)
+	}
+}
+
+// StopDeploys cancels the deployments in progress and waits until they
+// cleaned up after themselves.
+func StopDeploys() {
+	stopDeploys(errShutdown)
+	deploying.Wait()
+}
@@ -0,0 +1,169 @@
+package deployer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// state is what the watcher persists to -state to resume after a restart.
+type state struct {
+	Side    int            `json:"side"`
+	Head    string         `json:"head"`
+	Dir     string         `json:"dir"`
+	PID     int            `json:"pid,omitempty"`
+	Network string         `json:"network,omitempty"`
+	Addr    string         `json:"addr,omitempty"`
+	History []stateHistory `json:"history"`
+
+	// Peers are the other replicas of the serving side.
+	Peers []statePeer `json:"peers,omitempty"`
+}
+
+type statePeer struct {
+	PID     int    `json:"pid"`
+	Network string `json:"network"`
+	Addr    string `json:"addr"`
+}
+
+type stateHistory struct {
+	Head  string    `json:"head"`
+	Dir   string    `json:"dir"`
+	Built time.Time `json:"built"`
+	Image string    `json:"image,omitempty"`
+}
+
+// saveState writes the deployment state to -state. The caller must hold
+// m.mu.
+func (m *Manager) saveState() {
+	if m.statePath == "" {
+		return
+	}
+
+	st := state{Side: m.side, Head: m.last, Dir: m.dir}
+	if b := m.backend; b != nil && b.alive() {
+		st.PID = b.process.Pid
+		st.Network = b.network
+		st.Addr = b.addr
+
+		for _, peer := range b.peerList() {
+			if peer.alive() {
+				st.Peers = append(st.Peers, statePeer{PID: peer.process.Pid, Network: peer.network, Addr: peer.addr})
+			}
+		}
+	}
+
+	for _, d := range m.history {
+		st.History = append(st.History, stateHistory{Head: d.head, Dir: d.dir, Built: d.built, Image: d.image})
+	}
+
+	body, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		logger(subSupervisor).Error("Marshal state failed", "err", err)
+		return
+	}
+
+	tmp := m.statePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, body, 0600); err != nil {
+		logger(subSupervisor).Error("Write state failed", "err", err)
+		return
+	}
+
+	if err := os.Rename(tmp, m.statePath); err != nil {
+		logger(subSupervisor).Error("Replace state failed", "err", err)
+	}
+}
+
+// loadState restores the deployment recorded in -state. The recorded
+// instance is adopted when it still runs, otherwise it is started again
+// from its build directory.
+func (m *Manager) loadState() error {
+	if m.statePath == "" {
+		return nil
+	}
+
+	body, err := ioutil.ReadFile(m.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "read state")
+	}
+
+	var st state
+	if err := json.Unmarshal(body, &st); err != nil {
+		return errors.Wrap(err, "unmarshal state")
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	for _, h := range st.History {
+		if _, err := os.Stat(h.Dir); err != nil {
+			continue
+		}
+		m.history = append(m.history, &deployment{head: h.Head, dir: h.Dir, built: h.Built, image: h.Image})
+	}
+
+	if len(m.history) == 0 || m.history[0].head != st.Head {
+		logger(subSupervisor).Warn("Build of recorded head is gone", "sha", st.Head)
+		return nil
+	}
+
+	m.side = st.Side
+	m.dir = st.Dir
+	m.last = st.Head
+
+	// The cluster or nobody runs the instances.
+	if !runsLocally() {
+		return nil
+	}
+
+	if st.PID != 0 {
+		b, err := adoptBackend(st.PID, st.Network, st.Addr)
+		if err == nil {
+			b.retry = m.retryFor(b)
+			b.head = st.Head
+			for _, sp := range st.Peers {
+				peer, err := adoptBackend(sp.PID, sp.Network, sp.Addr)
+				if err != nil {
+					m.logger(subSupervisor).Warn("Adopt recorded replica failed", "pid", sp.PID, "err", err)
+					continue
+				}
+				peer.retry = m.retryFor(peer)
+				peer.head = st.Head
+				b.peers = append(b.peers, peer)
+			}
+			m.backend = b
+			m.reroute()
+			go m.supervise(b)
+			for i, peer := range b.peers {
+				go m.supervisePeer(b, peer, m.history[0], m.side, i+1)
+			}
+			go b.checkReplicas()
+			m.logger(subSupervisor).Info("Adopted instance", "pid", st.PID)
+			return nil
+		}
+		m.logger(subSupervisor).Warn("Adopt recorded instance failed", "pid", st.PID, "err", err)
+	}
+
+	side := m.otherSide()
+	b, err := m.launch(deployCtx, side, m.history[0])
+	if err != nil {
+		m.last = ""
+		return errors.Wrapf(err, "restart recorded %s", st.Head)
+	}
+
+	m.backend = b
+	m.reroute()
+	go m.supervise(b)
+	m.side = side
+	m.saveState()
+
+	m.logger(subSupervisor).Info("Restarted recorded build")
+
+	return nil
+}
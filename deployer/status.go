@@ -0,0 +1,215 @@
+package deployer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deployment states reported by /_status.
+const (
+	phaseIdle      = "idle"
+	phaseBuilding  = "building"
+	phaseSwitching = "switching"
+	phaseFailed    = "failed"
+)
+
+// phase tracks what the deploy worker is doing. It has its own lock as
+// m.mu is held for a whole build.
+type phase struct {
+	mu       sync.Mutex
+	state    string
+	head     string
+	since    time.Time
+	deployed time.Time
+}
+
+func (ph *phase) set(state, head string) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	ph.state, ph.head, ph.since = state, head, time.Now()
+	if state == phaseIdle && head != "" {
+		ph.deployed = ph.since
+	}
+}
+
+type statusInstance struct {
+	Head   string  `json:"head"`
+	Side   int     `json:"side"`
+	Port   int     `json:"port,omitempty"`
+	Addr   string  `json:"addr,omitempty"`
+	Uptime float64 `json:"uptime_seconds,omitempty"`
+}
+
+type statusRetained struct {
+	Head  string    `json:"head"`
+	Built time.Time `json:"built"`
+}
+
+// statusReport is the body of /_status.
+type statusReport struct {
+	Head       string    `json:"head"`
+	Side       int       `json:"side"`
+	Dir        string    `json:"dir"`
+	Port       int       `json:"port"`
+	Addr       string    `json:"addr"`
+	Uptime     float64   `json:"uptime_seconds"`
+	Replicas   int       `json:"replicas,omitempty"`
+	State      string    `json:"state"`
+	StateHead  string    `json:"state_head,omitempty"`
+	StateSince time.Time `json:"state_since"`
+	Deployed   time.Time `json:"deployed,omitempty"`
+	Failure    string    `json:"failure,omitempty"`
+
+	Queued     string `json:"queued,omitempty"`
+	QueueDepth int    `json:"queue_depth"`
+	Held       bool   `json:"held"`
+
+	Retained    []statusRetained `json:"retained"`
+	Crashes     int              `json:"crashes"`
+	Consecutive int              `json:"consecutive_crashes"`
+	Canary      *statusInstance  `json:"canary,omitempty"`
+	Staged      *statusInstance  `json:"staged,omitempty"`
+	RolledBack  string           `json:"rolled_back,omitempty"`
+	Bans        []ban            `json:"bans"`
+
+	Watcher BuildInfo `json:"watcher"`
+}
+
+func candidateStatus(c *candidate, now time.Time) *statusInstance {
+	return &statusInstance{
+		Head:   c.deployment.head,
+		Side:   c.side,
+		Port:   c.backend.port,
+		Addr:   c.backend.addr,
+		Uptime: now.Sub(c.backend.started).Seconds(),
+	}
+}
+
+// status collects the status report. Like the old plain-text status it
+// does not take m.mu, which is held for whole builds, but reads the state
+// last published with the routing.
+func (m *Manager) status() statusReport {
+	now := time.Now()
+
+	m.phase.mu.Lock()
+	s := statusReport{
+		State:      m.phase.state,
+		StateHead:  m.phase.head,
+		StateSince: m.phase.since,
+		Deployed:   m.phase.deployed,
+		Watcher:    config().Build,
+	}
+	m.phase.mu.Unlock()
+
+	rt := m.route()
+	s.Head, s.Side, s.Dir, s.Failure = rt.last, rt.side, rt.dir, rt.failure
+	if b := rt.backend; b != nil {
+		s.Port, s.Addr = b.port, b.addr
+		s.Uptime = now.Sub(b.started).Seconds()
+		if len(b.peerList()) > 0 {
+			s.Replicas = len(b.healthy())
+		}
+	}
+
+	if s.Queued = m.queue.pending(); s.Queued != "" {
+		s.QueueDepth = 1
+	}
+	s.Held = m.queue.held(now)
+
+	s.Retained = []statusRetained{}
+	for _, d := range rt.history {
+		s.Retained = append(s.Retained, statusRetained{Head: d.head, Built: d.built})
+	}
+
+	s.Crashes, s.Consecutive = rt.crashes, rt.consecutive
+
+	if c := rt.canary; c != nil {
+		s.Canary = candidateStatus(c, now)
+	}
+
+	if st := rt.staged; st != nil {
+		s.Staged = candidateStatus(st.candidate, now)
+	}
+
+	s.RolledBack = rt.rolledBack
+
+	s.Bans = m.bans.list(now)
+	if s.Bans == nil {
+		s.Bans = []ban{}
+	}
+
+	return s
+}
+
+// wantsText reports whether r prefers the plain-text status, JSON is
+// served otherwise.
+func wantsText(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "text" {
+		return true
+	}
+
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "application/json")
+}
+
+func writeStatusJSON(w http.ResponseWriter, s statusReport) {
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s)
+}
+
+// writeStatusText writes s in the key=value lines /_status used to serve.
+func writeStatusText(w io.Writer, s statusReport) {
+	fmt.Fprintf(w, "side=%d\nhead=%s\ndir=%s\nport=%d\naddr=%s", s.Side, s.Head, s.Dir, s.Port, s.Addr)
+	fmt.Fprintf(w, "\nstate=%s", s.State)
+
+	if s.Replicas > 0 {
+		fmt.Fprintf(w, "\nreplicas=%d", s.Replicas)
+	}
+
+	if s.Queued != "" {
+		fmt.Fprintf(w, "\nqueued=%s", s.Queued)
+	}
+
+	if s.Held {
+		fmt.Fprint(w, "\nheld=true")
+	}
+
+	if s.Failure != "" {
+		fmt.Fprintf(w, "\nfailure=%s", s.Failure)
+	}
+
+	for _, d := range s.Retained {
+		fmt.Fprintf(w, "\nretained=%s built=%s", d.Head, d.Built.Format(time.RFC3339))
+	}
+
+	if s.Crashes > 0 {
+		fmt.Fprintf(w, "\ncrashes=%d consecutive=%d", s.Crashes, s.Consecutive)
+	}
+
+	if c := s.Canary; c != nil {
+		fmt.Fprintf(w, "\ncanary=%s side=%d port=%d", c.Head, c.Side, c.Port)
+	}
+
+	if st := s.Staged; st != nil {
+		fmt.Fprintf(w, "\nstaged=%s side=%d port=%d", st.Head, st.Side, st.Port)
+	}
+
+	if s.RolledBack != "" {
+		fmt.Fprintf(w, "\nrolled_back=%s", s.RolledBack)
+	}
+
+	fmt.Fprintf(w, "\nwatcher=%s commit=%s", s.Watcher.Version, s.Watcher.Commit)
+
+	for _, b := range s.Bans {
+		fmt.Fprintf(w, "\nbanned=%s until=%s", b.IP, b.Until.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,63 @@
+package deployer
+
+import (
+	"hash/fnv"
+	"net/http"
+)
+
+// stickyCookie names the build a client was sent to with -sticky=cookie.
+const stickyCookie = "_watcher_build"
+
+// sticky returns the backend a returning client was sent to before, when it
+// is still alive: the serving one or the canary. A backend being drained
+// gets no new requests, or it might never finish draining.
+func (rt *routing) sticky(r *http.Request) *backend {
+	if config().Sticky != "cookie" {
+		return nil
+	}
+
+	ck, err := r.Cookie(stickyCookie)
+	if err != nil {
+		return nil
+	}
+
+	candidates := []*backend{rt.backend}
+	if c := rt.canary; c != nil {
+		candidates = append(candidates, c.backend)
+	}
+
+	for _, b := range candidates {
+		if b != nil && b.head == ck.Value && b.alive() {
+			return b
+		}
+	}
+
+	return nil
+}
+
+// stick sets the sticky cookie of b when a canary receives traffic beside
+// the serving build.
+func (rt *routing) stick(w http.ResponseWriter, b *backend) {
+	if config().Sticky != "cookie" || w == nil || b == nil {
+		return
+	}
+
+	if rt.canary == nil {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stickyCookie,
+		Value:    b.head,
+		Path:     "/",
+		HttpOnly: true,
+	})
+}
+
+// percentile maps the client of r to a stable number in [0, 100).
+func percentile(r *http.Request) int {
+	h := fnv.New32a()
+	h.Write([]byte(config().Trusted.ClientIP(r)))
+
+	return int(h.Sum32() % 100)
+}
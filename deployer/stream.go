@@ -0,0 +1,194 @@
+package deployer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveBacklog is how much output of the current deployment is kept for
+// subscribers joining late.
+const liveBacklog = 1 << 20
+
+// liveLog fans the build and startup output of the current deployment out
+// to subscribers of /_deployments/current/logs/stream.
+type liveLog struct {
+	mu   sync.Mutex
+	head string
+	buf  []byte
+	done bool
+	subs map[chan []byte]struct{}
+}
+
+func newLiveLog() *liveLog {
+	return &liveLog{done: true, subs: map[chan []byte]struct{}{}}
+}
+
+// begin starts the output of a deployment of head, ending the previous
+// one.
+func (l *liveLog) begin(head string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closeSubs()
+	l.head, l.buf, l.done = head, nil, false
+}
+
+// end ends the output of the current deployment.
+func (l *liveLog) end() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closeSubs()
+	l.done = true
+}
+
+// closeSubs ends all subscriptions. The caller must hold l.mu.
+func (l *liveLog) closeSubs() {
+	for ch := range l.subs {
+		close(ch)
+		delete(l.subs, ch)
+	}
+}
+
+// writer returns a writer feeding l while it carries the output of head.
+func (l *liveLog) writer(head string) io.Writer {
+	return liveWriter{l: l, head: head}
+}
+
+type liveWriter struct {
+	l    *liveLog
+	head string
+}
+
+func (w liveWriter) Write(b []byte) (int, error) {
+	l := w.l
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.done || l.head != w.head {
+		return len(b), nil
+	}
+
+	l.buf = append(l.buf, b...)
+	if len(l.buf) > liveBacklog {
+		l.buf = l.buf[len(l.buf)-liveBacklog:]
+	}
+
+	chunk := append([]byte(nil), b...)
+	for ch := range l.subs {
+		select {
+		case ch <- chunk:
+		default:
+			// Too slow to keep up, let it reconnect.
+			close(ch)
+			delete(l.subs, ch)
+		}
+	}
+
+	return len(b), nil
+}
+
+// subscribe returns the head and the output so far of the current
+// deployment and a channel receiving the output to come, which is closed
+// when the deployment ends. ch is nil when no deployment is in progress.
+func (l *liveLog) subscribe() (head string, backlog []byte, ch chan []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	backlog = append([]byte(nil), l.buf...)
+	if l.done {
+		return l.head, backlog, nil
+	}
+
+	ch = make(chan []byte, 64)
+	l.subs[ch] = struct{}{}
+
+	return l.head, backlog, ch
+}
+
+// unsubscribe stops sending to ch.
+func (l *liveLog) unsubscribe(ch chan []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.subs[ch]; ok {
+		close(ch)
+		delete(l.subs, ch)
+	}
+}
+
+// ended reports whether the deployment of head is over.
+func (l *liveLog) ended(head string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.done || l.head != head
+}
+
+// writeEvent writes one server-sent event, each line of data becoming a
+// data field.
+func writeEvent(w io.Writer, event string, data []byte) {
+	fmt.Fprintf(w, "event: %s\n", event)
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// streamLogs streams the output of the current deployment to w as
+// server-sent events until it ends or the client goes away.
+func (l *liveLog) streamLogs(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	head, backlog, ch := l.subscribe()
+	if ch != nil {
+		defer l.unsubscribe(ch)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	writeEvent(w, "deployment", []byte(head))
+	if len(backlog) > 0 {
+		writeEvent(w, "log", backlog)
+	}
+	rc.Flush()
+
+	if ch == nil {
+		writeEvent(w, "end", nil)
+		return
+	}
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case chunk, ok := <-ch:
+			if !ok {
+				// Slow subscribers are dropped while the deployment
+				// goes on, they get no end event and reconnect.
+				if l.ended(head) {
+					writeEvent(w, "end", nil)
+				}
+				return
+			}
+			writeEvent(w, "log", chunk)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package deployer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// stableAfter is how long an instance has to run before its crash is no
+// longer counted as consecutive to the previous one.
+const stableAfter = time.Minute
+
+// supervise restarts the instance of b when it exits without being
+// stopped. After -max-restarts consecutive crashes it rolls back to the
+// previous build instead.
+func (m *Manager) supervise(b *backend) {
+	<-b.done
+
+	if atomic.LoadInt32(&b.stopping) == 1 {
+		return
+	}
+
+	logger(subSupervisor).Warn("Instance exited", "sha", b.head, "err", b.err)
+
+	m.mu.Lock()
+	if m.backend != b {
+		m.unlock()
+		return
+	}
+
+	m.crashes++
+	if time.Since(b.started) > stableAfter {
+		m.consecutive = 0
+	}
+	m.consecutive++
+	consecutive := m.consecutive
+	m.unlock()
+
+	if consecutive > config().MaxRestarts {
+		m.crashRollback(b)
+		return
+	}
+
+	backoff := config().RestartBackoff << uint(consecutive-1)
+	if backoff > config().MaxRestartBackoff || backoff <= 0 {
+		backoff = config().MaxRestartBackoff
+	}
+	select {
+	case <-time.After(backoff):
+	case <-deployCtx.Done():
+		return
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.backend != b || len(m.history) == 0 {
+		return
+	}
+
+	if err := m.relaunch(); err != nil {
+		m.logger(subSupervisor).Error("Restart crashed instance failed", "err", err)
+		m.failure = errors.Wrap(err, "restart").Error()
+		// Let the supervisor of the dead instance try again.
+		go m.supervise(b)
+		return
+	}
+
+	m.metrics.restart()
+	m.logger(subSupervisor).Info("Restarted after crash", "crash", consecutive)
+}
+
+// supervisePeer restarts peer, replica i of the side led by b running d,
+// when it exits while b still serves. It backs off like supervise and rolls
+// back after -max-restarts consecutive crashes.
+func (m *Manager) supervisePeer(b, peer *backend, d *deployment, side, i int) {
+	l := logger(subSupervisor).With("sha", d.head, "replica", i)
+	consecutive := 0
+
+	for {
+		select {
+		case <-peer.done:
+		case <-b.done:
+			return
+		}
+
+		if atomic.LoadInt32(&peer.stopping) == 1 || atomic.LoadInt32(&b.stopping) == 1 {
+			return
+		}
+
+		l.Warn("Replica exited", "err", peer.err)
+
+		if time.Since(peer.started) > stableAfter {
+			consecutive = 0
+		}
+		consecutive++
+
+		m.mu.Lock()
+		if m.backend == b {
+			m.crashes++
+		}
+		m.unlock()
+
+		if consecutive > config().MaxRestarts {
+			m.crashRollback(b)
+			return
+		}
+
+		backoff := config().RestartBackoff << uint(consecutive-1)
+		if backoff > config().MaxRestartBackoff || backoff <= 0 {
+			backoff = config().MaxRestartBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-b.done:
+			return
+		case <-deployCtx.Done():
+			return
+		}
+
+		// m.mu keeps the port of the restart from being handed out twice.
+		m.mu.Lock()
+		if atomic.LoadInt32(&b.stopping) == 1 {
+			m.unlock()
+			return
+		}
+		np, err := m.launchOne(deployCtx, side, d, i)
+		m.unlock()
+		if err != nil {
+			l.Error("Restart crashed replica failed", "err", err)
+			// peer.done stays closed, the next round backs off further.
+			continue
+		}
+
+		if !b.replacePeer(peer, np) {
+			np.stopOne(config().DrainGrace)
+			return
+		}
+
+		m.metrics.restart()
+		l.Info("Restarted replica after crash", "crash", consecutive)
+		peer = np
+	}
+}
+
+// relaunch starts the current build on the other side and switches traffic
+// to it. The caller must hold m.mu.
+func (m *Manager) relaunch() error {
+	side := m.otherSide()
+	nb, err := m.launch(deployCtx, side, m.history[0])
+	if err != nil {
+		return err
+	}
+
+	if err := m.switchTo(nb); err != nil {
+		m.logger(subSupervisor).Error("Switch failed", "err", err)
+	}
+	m.side = side
+	m.saveState()
+
+	return nil
+}
+
+// crashRollback rolls back from the build of b which keeps crashing.
+func (m *Manager) crashRollback(b *backend) {
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.backend != b {
+		return
+	}
+
+	m.failure = "instance of " + m.last + " keeps crashing"
+	ev := deployEvent{kind: eventCrashing, head: m.last}
+	if b.err != nil {
+		ev.err = b.err.Error()
+	}
+	m.notify(ev)
+
+	if len(m.history) < 2 {
+		m.logger(subSupervisor).Error("Instance keeps crashing and there is nothing to roll back to")
+		return
+	}
+
+	bad := m.last
+	if err := m.rollBack(deployCtx); err != nil {
+		m.logger(subSupervisor).Error("Rollback crashing instance failed", "err", err)
+		return
+	}
+	m.consecutive = 0
+
+	m.logger(subSupervisor).Info("Rolled back from crashing build", "from", bad)
+	m.notify(deployEvent{kind: eventRolledBack, head: m.last, from: bad, err: "instance kept crashing"})
+}
@@ -0,0 +1,149 @@
+package deployer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/romanyx/watcher/proxy"
+)
+
+// TestSwitchUnderLoad sends requests through the set while the serving
+// build is switched and canaries are promoted and dropped. Each build
+// answers with its head and stops answering once it was stopped, so a
+// request routed to a stopped build fails. Run it with -race: requests
+// read the routing without m.mu.
+func TestSwitchUnderLoad(t *testing.T) {
+	s, err := NewSet(&Config{
+		Builder:       "none",
+		Retain:        2,
+		Transport:     proxy.Transport{Proto: "http", DialTimeout: time.Second},
+		UpgradeSwitch: "drain",
+		DrainGrace:    time.Second,
+		Canary:        50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Add(App{Name: "app", Ports: "0"}); err != nil {
+		t.Fatal(err)
+	}
+	m := s.list[0]
+	if err := m.mount(config()); err != nil {
+		t.Fatal(err)
+	}
+
+	start := func(head string) *candidate {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, head)
+		}))
+
+		b, err := newBackend("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.head = head
+		b.halt = func() error {
+			srv.Close()
+			close(b.done)
+			return nil
+		}
+
+		return &candidate{backend: b, deployment: &deployment{head: head, dir: t.TempDir()}, side: m.otherSide()}
+	}
+
+	get := func() (int, string) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Code, w.Body.String()
+	}
+
+	expect := func(head string) {
+		t.Helper()
+		if code, body := get(); code != http.StatusOK || body != head {
+			t.Fatalf("got %d %q, want %q", code, body, head)
+		}
+	}
+
+	m.mu.Lock()
+	m.promote(start("first"))
+	m.unlock()
+	expect("first")
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				if code, body := get(); code != http.StatusOK {
+					t.Errorf("got %d %q during a switch", code, body)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		head := fmt.Sprintf("switch%d", i)
+		m.mu.Lock()
+		c := start(head)
+		if err := m.switchTo(c.backend); err != nil {
+			t.Error(err)
+		}
+		m.side = c.side
+		m.last = head
+		m.unlock()
+		expect(head)
+
+		canary := fmt.Sprintf("canary%d", i)
+		m.mu.Lock()
+		m.startCanary(start(canary))
+		m.unlock()
+
+		if i%2 == 0 {
+			_, err = m.promoteCanary()
+			expect(canary)
+		} else {
+			_, err = m.abortCanary()
+			expect(head)
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	// A canary which exits on its own is dropped.
+	m.mu.Lock()
+	c := start("crashed")
+	m.startCanary(c)
+	m.unlock()
+	c.backend.halt()
+
+	for deadline := time.Now().Add(time.Second); m.route().canary != nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("exited canary still routed")
+		}
+	}
+	expect(m.route().backend.head)
+
+	m.stop()
+	if code, _ := get(); code != http.StatusServiceUnavailable {
+		t.Errorf("got %d after stop, want %d", code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,298 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/romanyx/watcher/proxy"
+)
+
+// span is one traced operation, exported over OTLP once ended. A nil span
+// is a no-op so callers need not check whether tracing is enabled.
+type span struct {
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	kind    int
+	start   time.Time
+	end     time.Time
+	attrs   map[string]string
+	err     error
+}
+
+// OTLP span kinds.
+const (
+	spanInternal = 1
+	spanServer   = 2
+)
+
+type spanKey struct{}
+
+// tracer batches ended spans and posts them to -otlp-endpoint.
+type tracer struct {
+	endpoint string
+	service  string
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+// tracing is the tracer, nil when -otlp-endpoint is not set.
+var tracing *tracer
+
+func newTracer(endpoint, service string) *tracer {
+	t := &tracer{endpoint: strings.TrimSuffix(endpoint, "/") + "/v1/traces", service: service}
+	go t.loop()
+
+	return t
+}
+
+// EnableTracing traces deployments, and proxied requests with
+// -trace-requests, to the OTLP/HTTP collector at endpoint as service.
+func EnableTracing(endpoint, service string) {
+	tracing = newTracer(endpoint, service)
+}
+
+// FlushTracing exports the spans not exported yet.
+func FlushTracing() {
+	if tracing != nil {
+		tracing.flush()
+	}
+}
+
+// startSpan starts a span named name as a child of the span in ctx and
+// returns ctx carrying it.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	if tracing == nil {
+		return ctx, nil
+	}
+
+	s := &span{name: name, kind: spanInternal, start: time.Now(), attrs: map[string]string{}}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok && parent != nil {
+		s.traceID, s.parent = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) set(key, value string) {
+	if s != nil {
+		s.attrs[key] = value
+	}
+}
+
+// finish ends s, failed when err is not nil, and queues it for export.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+
+	s.end, s.err = time.Now(), err
+	tracing.add(s)
+}
+
+// traceparent returns the W3C traceparent header value of s.
+func (s *span) traceparent() string {
+	return fmt.Sprintf("00-%x-%x-01", s.traceID, s.spanID)
+}
+
+// parseTraceparent returns the trace and parent span ids of a W3C
+// traceparent header value.
+func parseTraceparent(v string) (trace [16]byte, parent [8]byte, ok bool) {
+	parts := strings.Split(v, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return trace, parent, false
+	}
+
+	if _, err := hex.Decode(trace[:], []byte(parts[1])); err != nil {
+		return trace, parent, false
+	}
+
+	if _, err := hex.Decode(parent[:], []byte(parts[2])); err != nil {
+		return trace, parent, false
+	}
+
+	return trace, parent, trace != [16]byte{}
+}
+
+func (t *tracer) add(s *span) {
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	full := len(t.spans) >= 512
+	t.mu.Unlock()
+
+	if full {
+		go t.flush()
+	}
+}
+
+func (t *tracer) loop() {
+	for range time.Tick(5 * time.Second) {
+		t.flush()
+	}
+}
+
+// flush exports the queued spans.
+func (t *tracer) flush() {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	if err := t.export(spans); err != nil {
+		logger(subWatcher).Warn("Export spans failed", "spans", len(spans), "err", err)
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         int        `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       otlpStatus `json:"status"`
+}
+
+func attrs(m map[string]string) []otlpAttr {
+	var list []otlpAttr
+	for k, v := range m {
+		list = append(list, otlpAttr{Key: k, Value: otlpValue{v}})
+	}
+
+	return list
+}
+
+// export posts spans to the collector in the OTLP/HTTP JSON encoding.
+func (t *tracer) export(spans []*span) error {
+	var out []otlpSpan
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:    hex.EncodeToString(s.traceID[:]),
+			SpanID:     hex.EncodeToString(s.spanID[:]),
+			Name:       s.name,
+			Kind:       s.kind,
+			Start:      strconv.FormatInt(s.start.UnixNano(), 10),
+			End:        strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes: attrs(s.attrs),
+			Status:     otlpStatus{Code: 1},
+		}
+
+		if s.parent != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+
+		if s.err != nil {
+			o.Status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+
+		out = append(out, o)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": attrs(map[string]string{"service.name": t.service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "watcher"},
+				"spans": out,
+			}},
+		}},
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal spans")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "post spans")
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("post spans: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// traceRequests traces requests served by h, continuing the trace of an
+// incoming traceparent and passing the span on to the instance.
+func traceRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &span{name: r.Method + " " + r.URL.Path, kind: spanServer, start: time.Now(), attrs: map[string]string{}}
+		if trace, parent, ok := parseTraceparent(r.Header.Get("Traceparent")); ok {
+			s.traceID, s.parent = trace, parent
+		} else {
+			rand.Read(s.traceID[:])
+		}
+		rand.Read(s.spanID[:])
+
+		s.set("http.request.method", r.Method)
+		s.set("url.path", r.URL.Path)
+		s.set("client.address", config().Trusted.ClientIP(r))
+
+		r.Header.Set("Traceparent", s.traceparent())
+		r = r.WithContext(context.WithValue(r.Context(), spanKey{}, s))
+
+		sw := &proxy.StatusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+
+		status := sw.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		s.set("http.response.status_code", strconv.Itoa(status))
+
+		var err error
+		if status >= 500 {
+			err = errors.New(http.StatusText(status))
+		}
+		s.finish(err)
+	})
+}
@@ -0,0 +1,23 @@
+package deployer
+
+import "fmt"
+
+// BuildInfo describes the running watcher binary.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	Go        string `json:"go"`
+}
+
+func (bi BuildInfo) String() string {
+	s := "watcher " + bi.Version
+	if bi.Commit != "" {
+		s += " " + shortSha(bi.Commit)
+	}
+	if bi.BuildDate != "" {
+		s += " built " + bi.BuildDate
+	}
+
+	return fmt.Sprintf("%s %s", s, bi.Go)
+}
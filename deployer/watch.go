@@ -0,0 +1,130 @@
+package deployer
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// ignored reports whether changes to the file name don't trigger a build:
+// hidden files and the backups and swap files of editors.
+func ignored(name string) bool {
+	base := filepath.Base(name)
+	return strings.HasPrefix(base, ".") || strings.HasSuffix(base, "~") || strings.HasSuffix(base, ".swp")
+}
+
+// treeHead returns a fingerprint of the tree under dir which stands in for
+// the commit in -watch-dir mode. It changes when a file is added, removed
+// or modified.
+func treeHead(dir string) (string, error) {
+	h := sha1.New()
+
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && ignored(path) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%v\n", path, fi.Size(), fi.ModTime().UnixNano(), fi.Mode())
+		return nil
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "walk watched tree")
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// watchTree adds dir and the directories below it to w.
+func watchTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if path != dir && ignored(path) {
+			return filepath.SkipDir
+		}
+
+		return w.Add(path)
+	})
+}
+
+// watchLoop queues a build of -watch-dir once changes to it settle for
+// -watch-debounce. It must be started once.
+func (m *Manager) watchLoop() {
+	l := logger(subWatcher)
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		l.Error("Watch failed", "err", err)
+		return
+	}
+	defer w.Close()
+
+	if err := watchTree(w, config().WatchDir); err != nil {
+		l.Error("Watch failed", "dir", config().WatchDir, "err", err)
+		return
+	}
+
+	settle := time.NewTimer(time.Hour)
+	settle.Stop()
+
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if ignored(ev.Name) {
+				continue
+			}
+			if ev.Has(fsnotify.Create) {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					if err := watchTree(w, ev.Name); err != nil {
+						l.Warn("Watch new directory failed", "dir", ev.Name, "err", err)
+					}
+				}
+			}
+			settle.Reset(config().WatchDebounce)
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			l.Warn("Watch error", "err", err)
+
+		case <-settle.C:
+			head, err := treeHead(config().WatchDir)
+			if err != nil {
+				l.Error("Fingerprint tree failed", "err", err)
+				continue
+			}
+
+			if head == m.queue.latest() {
+				continue
+			}
+
+			l.Info("Tree changed", "sha", shortSha(head))
+			m.queue.push(head, triggerWatch)
+			m.notify(deployEvent{kind: eventReceived, head: head, trigger: triggerWatch})
+
+		case <-deployCtx.Done():
+			return
+		}
+	}
+}
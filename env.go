@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// secretFlags are the flags whose value may be read from a file named by
+// their -file twin, like -secret-file, to keep it out of ps.
+var secretFlags = []string{"secret", "github-token", "smtp-password", "telegram-token", "event-hook-secret", "read-auth", "control-auth", "registry-password"}
+
+// envName returns the environment variable of flag name, WATCHER_REPO for
+// -repo.
+func envName(name string) string {
+	return "WATCHER_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// load sets the flags of o on fs which were not given on the command
+// line: from the environment first, then from the -config file. Secret
+// flags are read from their -file twins last.
+func (o *options) load(fs *flag.FlagSet) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	if err := loadEnv(fs, given); err != nil {
+		return err
+	}
+
+	if o.configPath != "" {
+		if err := loadConfig(fs, o.configPath, given); err != nil {
+			return err
+		}
+	}
+
+	return o.loadSecretFiles(fs)
+}
+
+// loadEnv sets the flags of fs not in given from their WATCHER_ environment
+// variables and adds them to given. Repeatable flags take one value per
+// line.
+func loadEnv(fs *flag.FlagSet, given map[string]bool) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		given[f.Name] = true
+
+		values := []string{v}
+		if _, repeatable := f.Value.(*stringList); repeatable {
+			values = strings.Split(strings.TrimSpace(v), "\n")
+		}
+
+		for _, v := range values {
+			if setErr := f.Value.Set(v); setErr != nil {
+				err = errors.Errorf("invalid value of %s for -%s: %v", envName(f.Name), f.Name, setErr)
+				return
+			}
+		}
+	})
+
+	return err
+}
+
+// loadSecretFiles sets the secret flags of o on fs which are still empty
+// from their -file twins.
+func (o *options) loadSecretFiles(fs *flag.FlagSet) error {
+	for _, name := range secretFlags {
+		path := *o.secretFiles[name]
+		if path == "" {
+			continue
+		}
+
+		f := fs.Lookup(name)
+		if f.Value.String() != "" {
+			return errors.Errorf("both -%s and -%s-file are set", name, name)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "read -%s-file", name)
+		}
+
+		if err := f.Value.Set(strings.TrimRight(string(data), "\r\n")); err != nil {
+			return errors.Wrapf(err, "set -%s", name)
+		}
+	}
+
+	return nil
+}
+
+// childEnv returns the environment of the watcher without its WATCHER_
+// settings, which may hold secrets, for builds and instances. The
+// variables of -secret-env are added.
+func childEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "WATCHER_") {
+			env = append(env, kv)
+		}
+	}
+
+	return append(env, secretEnv()...)
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/romanyx/watcher/webhook"
+)
+
+// options holds the values of the flags. The flags of the running
+// watcher are opts, a reload reads the settings into new options.
+type options struct {
+	showVersion bool
+
+	adminSocket     string
+	adminAddr       string
+	readAuthSpec    string
+	controlAuthSpec string
+
+	configPath string
+	hostPort   string
+	repoName   string
+	branch     string
+	domainName string
+	logPath    string
+	logLevel   string
+	logFormat  string
+	logMaxSize int64
+	logMaxAge  time.Duration
+	logRetain  int
+	secret     string
+	binary     string
+
+	httpAddr  string
+	httpsAddr string
+	tlsCert   string
+	tlsKey    string
+
+	acmeDomainList string
+	acmeCache      string
+	acmeEmail      string
+
+	banThreshold int
+	banDuration  time.Duration
+	pushRate     int
+
+	requireSigned  bool
+	gpgHome        string
+	allowedSigners string
+
+	githubToken string
+	waitCI      bool
+	ciChecks    string
+	ciTimeout   time.Duration
+	ciInterval  time.Duration
+
+	healthPath      string
+	healthTimeout   time.Duration
+	healthInterval  time.Duration
+	healthThreshold int
+
+	canaryPercent int
+	canaryHeader  string
+	canaryCookie  string
+
+	ports      string
+	socketMode bool
+
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	idleConnTimeout       time.Duration
+	maxIdleConnsPerHost   int
+
+	backendProto    string
+	backendCA       string
+	backendInsecure bool
+
+	accessLogPath   string
+	accessLogFormat string
+
+	gzipEnabled bool
+	cacheRules  string
+
+	metricsPath string
+
+	allowRefresh time.Duration
+
+	stickyMode string
+
+	hold            time.Duration
+	maintenancePath string
+	retryAfter      int
+
+	trustedProxies string
+
+	runTmpl    string
+	runDirTmpl string
+	portEnv    string
+
+	replicaCount int
+
+	rollbackWindow         time.Duration
+	rollbackErrorRate      int
+	rollbackMinRequests    int
+	rollbackHealthFailures int
+
+	maxRestarts       int
+	restartBackoff    time.Duration
+	maxRestartBackoff time.Duration
+
+	logsDir    string
+	logsRetain int
+
+	approval       bool
+	approveTimeout time.Duration
+
+	buildLimits string
+	runLimits   string
+	cgroupRoot  string
+
+	minFree       int
+	retain        int
+	upgradeSwitch string
+	drainGrace    time.Duration
+
+	dryRun bool
+
+	pollInterval time.Duration
+
+	deployWindow string
+
+	redeploySchedule string
+	restartSchedule  string
+
+	secretsProvider string
+	secretsRefresh  time.Duration
+	gcpProject      string
+
+	githubRetries int
+
+	webhookRepos   string
+	webhookMaxBody int64
+
+	pluginTimeout time.Duration
+
+	eventHookSecret string
+
+	slackWebhook   string
+	discordWebhook string
+	telegramToken  string
+	telegramChat   string
+	publicURL      string
+
+	smtpAddr     string
+	smtpUser     string
+	smtpPassword string
+	mailFrom     string
+	mailTo       string
+
+	otlpEndpoint string
+	otlpService  string
+	traceProxied bool
+
+	journalPath string
+
+	statePath string
+	pidFile   string
+	shutdown  string
+
+	builderKind string
+	makeTarget  string
+	dockerImage string
+	buildScript string
+	installCmd  string
+
+	sshHosts    string
+	sshDir      string
+	sshStart    string
+	sshStop     string
+	sshPort     int
+	sshIdentity string
+
+	dockerPush       bool
+	pushOnly         bool
+	registryUser     string
+	registryPassword string
+
+	k8sDeployment string
+	k8sNamespace  string
+	k8sContainer  string
+	k8sImage      string
+	k8sTimeout    time.Duration
+	kubeconfig    string
+
+	leasePath    string
+	leaseTTL     time.Duration
+	instanceName string
+
+	watchDir      string
+	watchDebounce time.Duration
+
+	vcsKind      string
+	submodules   bool
+	buildTimeout time.Duration
+
+	// requestHeaders and responseHeaders are the header rules of proxied
+	// traffic, given with -request-header and -response-header. routeList
+	// holds the -route flags, allowList the -allow flags and eventHooks
+	// the -event-hook URLs. appList holds the -app specs, pluginList the
+	// -plugin commands. secretRefs and secretEnvRefs hold the -secret-ref
+	// and -secret-env rules.
+	requestHeaders, responseHeaders, routeList, allowList, eventHooks, appList, pluginList, secretRefs, secretEnvRefs stringList
+
+	// secretFiles holds the -<name>-file flags of secretFlags.
+	secretFiles map[string]*string
+}
+
+// opts are the flags of the running watcher, set up at startup.
+var opts = &options{}
+
+func init() {
+	opts.register(flag.CommandLine)
+}
+
+// register defines the flags of o on fs.
+func (o *options) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.showVersion, "version", false, "Print the version of the watcher and exit")
+
+	fs.StringVar(&o.adminSocket, "admin-socket", "", "Unix socket serving the watcher endpoints to local subcommands")
+	fs.StringVar(&o.adminAddr, "admin-addr", "", "Separate listener like localhost:9090 serving the read and control endpoints, which the public listeners then hide")
+	fs.StringVar(&o.readAuthSpec, "read-auth", "", "Credentials of the status, logs, deployments, dashboard and metrics endpoints: none, or comma separated bearer=TOKEN and basic=user:password, default is -secret as a bearer token")
+	fs.StringVar(&o.controlAuthSpec, "control-auth", "", "Credentials of the deploy, rollback, pause, resume, approve, canary and reload endpoints like -read-auth, default is -secret as a bearer token")
+
+	fs.StringVar(&o.configPath, "config", "", "YAML file with settings named like the flags, flags given on the command line take precedence")
+	fs.StringVar(&o.hostPort, "hostport", "localhost:8080", "server host and port")
+	fs.StringVar(&o.repoName, "repo", "", "Repo name")
+	fs.StringVar(&o.branch, "branch", "master", "Branch whose pushes are deployed")
+	fs.StringVar(&o.domainName, "domain", "", "Domain name, same as -acme-domains")
+	fs.StringVar(&o.logPath, "log", "", "Log file path, default is output")
+	fs.StringVar(&o.logLevel, "log-level", "info", "Lowest level logged: debug, info, warn or error")
+	fs.StringVar(&o.logFormat, "log-format", "text", "Log line format: text or json")
+	fs.Int64Var(&o.logMaxSize, "log-max-size", 0, "Rotate -log once it grows past this many megabytes, 0 disables")
+	fs.DurationVar(&o.logMaxAge, "log-max-age", 0, "Rotate -log once it is older than this, 0 disables")
+	fs.IntVar(&o.logRetain, "log-retain", 5, "Number of rotated -log files to keep, SIGUSR1 reopens -log")
+	fs.StringVar(&o.secret, "secret", "", "Github notification secret")
+	fs.StringVar(&o.binary, "binary", "default-name", "Builded binary name")
+
+	fs.StringVar(&o.httpAddr, "http-addr", ":http", "Plain HTTP listen address, redirecting to HTTPS")
+	fs.StringVar(&o.httpsAddr, "https-addr", ":https", "HTTPS listen address")
+	fs.StringVar(&o.tlsCert, "tls-cert", "", "TLS certificate file, default is a Let's Encrypt certificate for -acme-domains")
+	fs.StringVar(&o.tlsKey, "tls-key", "", "TLS key file of -tls-cert")
+
+	fs.StringVar(&o.acmeDomainList, "acme-domains", "", "Comma separated domains to obtain Let's Encrypt certificates for")
+	fs.StringVar(&o.acmeCache, "acme-cache", ".", "Directory Let's Encrypt certificates are cached in")
+	fs.StringVar(&o.acmeEmail, "acme-email", "", "Contact email for the Let's Encrypt account")
+
+	fs.IntVar(&o.banThreshold, "ban-threshold", 5, "Wrong webhook signatures after which the sender is banned, 0 never bans")
+	fs.DurationVar(&o.banDuration, "ban-duration", time.Hour, "How long senders of wrong webhook signatures are banned")
+	fs.IntVar(&o.pushRate, "push-rate", 60, "Webhook requests allowed per minute, 0 is unlimited")
+
+	fs.BoolVar(&o.requireSigned, "require-signed", false, "Refuse to deploy heads without a valid signature")
+	fs.StringVar(&o.gpgHome, "gpg-home", "", "GnuPG home with the keyring trusted for signed heads")
+	fs.StringVar(&o.allowedSigners, "allowed-signers", "", "SSH allowed signers file trusted for signed heads")
+
+	fs.StringVar(&o.githubToken, "github-token", "", "Github API token")
+	fs.BoolVar(&o.waitCI, "wait-ci", false, "Deploy pushed heads only after their CI checks passed")
+	fs.StringVar(&o.ciChecks, "ci-checks", "", "Comma separated names of required CI checks, default is all reported")
+	fs.DurationVar(&o.ciTimeout, "ci-timeout", 30*time.Minute, "How long to wait for CI of a pushed head")
+	fs.DurationVar(&o.ciInterval, "ci-interval", 15*time.Second, "How often to poll CI state of a pushed head")
+
+	fs.StringVar(&o.healthPath, "health-path", "", "Path probed on a new instance before switching traffic, empty disables the check")
+	fs.DurationVar(&o.healthTimeout, "health-timeout", 30*time.Second, "How long a new instance may take to become healthy")
+	fs.DurationVar(&o.healthInterval, "health-interval", time.Second, "Interval between health probes")
+	fs.IntVar(&o.healthThreshold, "health-threshold", 1, "Consecutive successful probes required")
+
+	fs.IntVar(&o.canaryPercent, "canary", 0, "Percent of requests sent to a new build until it is promoted, 0 switches all traffic at once")
+	fs.StringVar(&o.canaryHeader, "canary-header", "", "Requests with this header, as name or name=value, go to the canary")
+	fs.StringVar(&o.canaryCookie, "canary-cookie", "", "Requests with this cookie, as name or name=value, go to the canary")
+
+	fs.StringVar(&o.ports, "ports", "8081-8082", "Port range for started instances as lo-hi, 0 picks any free port")
+	fs.BoolVar(&o.socketMode, "socket", false, "Run instances on a unix socket in their build directory instead of a port")
+
+	fs.DurationVar(&o.readTimeout, "read-timeout", 0, "Front server timeout for reading a whole request, 0 is none")
+	fs.DurationVar(&o.readHeaderTimeout, "read-header-timeout", 10*time.Second, "Front server timeout for reading request headers")
+	fs.DurationVar(&o.writeTimeout, "write-timeout", 0, "Front server timeout for writing a response, 0 is none as it cuts streams and WebSockets")
+	fs.DurationVar(&o.idleTimeout, "idle-timeout", 2*time.Minute, "Front server keep-alive timeout")
+
+	fs.DurationVar(&o.dialTimeout, "proxy-dial-timeout", 5*time.Second, "Timeout for connecting to instances and upstreams")
+	fs.DurationVar(&o.tlsHandshakeTimeout, "proxy-tls-handshake-timeout", 10*time.Second, "TLS handshake timeout towards instances and upstreams")
+	fs.DurationVar(&o.responseHeaderTimeout, "proxy-response-header-timeout", 0, "How long to wait for response headers of instances and upstreams, 0 is forever")
+	fs.DurationVar(&o.idleConnTimeout, "proxy-idle-conn-timeout", 90*time.Second, "How long idle connections to instances and upstreams are kept")
+	fs.IntVar(&o.maxIdleConnsPerHost, "proxy-max-idle-conns-per-host", 32, "Idle connections kept per instance or upstream")
+
+	fs.StringVar(&o.backendProto, "backend-proto", "http", "Protocol spoken to instances: http, h2c for HTTP/2 and gRPC without TLS, or https")
+	fs.StringVar(&o.backendCA, "backend-ca", "", "CA certificates file to verify instances with -backend-proto=https")
+	fs.BoolVar(&o.backendInsecure, "backend-insecure", false, "Skip certificate verification of instances with -backend-proto=https")
+
+	fs.StringVar(&o.accessLogPath, "access-log", "", "File proxied requests are logged to, default is no access log")
+	fs.StringVar(&o.accessLogFormat, "access-log-format", "combined", "Access log format: common, combined or json with latency")
+
+	fs.BoolVar(&o.gzipEnabled, "gzip", false, "Compress text responses of instances with gzip")
+	fs.StringVar(&o.cacheRules, "cache-control", "", "Cache-Control set on responses as pattern=value rules separated by ;, a pattern ending in / matches the paths below")
+
+	fs.StringVar(&o.metricsPath, "metrics-path", "/metrics", "Path serving Prometheus metrics, empty to disable")
+
+	fs.DurationVar(&o.allowRefresh, "allow-refresh", time.Hour, "Interval to refresh GitHub's webhook ranges used by -allow rules")
+
+	fs.StringVar(&o.stickyMode, "sticky", "", "Keep clients on one build while two receive traffic: cookie, or ip to split canary traffic by client address")
+
+	fs.DurationVar(&o.hold, "hold", 0, "How long requests wait for an instance while none is up or the one they were sent to went away")
+	fs.StringVar(&o.maintenancePath, "maintenance-page", "", "HTML template served with 503 while no instance is up, {{.RetryAfter}} is available")
+	fs.IntVar(&o.retryAfter, "retry-after", 30, "Seconds clients are asked to wait by the maintenance page")
+
+	fs.StringVar(&o.trustedProxies, "trusted-proxies", "", "Comma separated CIDRs of proxies whose forwarding headers are passed on to instances")
+
+	fs.StringVar(&o.runTmpl, "run", "./{{.Binary}} -hostport=localhost:{{.Port}}", "Template of the command starting an instance, with {{.Binary}}, {{.Port}}, {{.Socket}}, {{.Side}}, {{.Replica}}, {{.Dir}} and {{.Sha}}, like \"node server.js\"")
+	fs.StringVar(&o.runDirTmpl, "run-dir", "{{.Dir}}", "Template of the working directory of an instance, with the -run placeholders")
+	fs.StringVar(&o.portEnv, "port-env", "PORT", "Environment variable instances get their port in, for commands without a port flag, empty disables")
+
+	fs.IntVar(&o.replicaCount, "replicas", 1, "Instances started per side, requests are balanced round-robin across the healthy ones")
+
+	fs.DurationVar(&o.rollbackWindow, "rollback-window", 0, "How long a new build is watched after the switch and rolled back when it goes bad, 0 disables")
+	fs.IntVar(&o.rollbackErrorRate, "rollback-error-rate", 10, "Percentage of requests failing with 5xx within -rollback-window which rolls back")
+	fs.IntVar(&o.rollbackMinRequests, "rollback-min-requests", 20, "Requests needed within -rollback-window before the error rate counts")
+	fs.IntVar(&o.rollbackHealthFailures, "rollback-health-failures", 3, "Consecutive -health-path failures within -rollback-window which roll back")
+
+	fs.IntVar(&o.maxRestarts, "max-restarts", 5, "Consecutive crashes of an instance before rolling back to the previous build")
+	fs.DurationVar(&o.restartBackoff, "restart-backoff", time.Second, "Delay before restarting a crashed instance, doubled on every consecutive crash")
+	fs.DurationVar(&o.maxRestartBackoff, "max-restart-backoff", time.Minute, "Upper bound of the restart delay")
+
+	fs.StringVar(&o.logsDir, "logs-dir", "", "Directory for per deployment build and run logs, default is output")
+	fs.IntVar(&o.logsRetain, "logs-retain", 10, "Number of deployments to keep logs of")
+
+	fs.BoolVar(&o.approval, "approval", false, "Hold healthy new builds back from traffic until POST /_approve")
+	fs.DurationVar(&o.approveTimeout, "approve-timeout", time.Hour, "How long a build waits for approval before it is discarded")
+
+	fs.StringVar(&o.buildLimits, "build-limits", "", "Resource limits of build commands like \"memory=2G cpu=2 nice=10 ioidle\", Linux only")
+	fs.StringVar(&o.runLimits, "run-limits", "", "Resource limits of each instance, like -build-limits")
+	fs.StringVar(&o.cgroupRoot, "cgroup", "/sys/fs/cgroup/watcher", "cgroup v2 directory, delegated to the watcher, limited commands get their cgroups in")
+
+	fs.IntVar(&o.minFree, "min-free", 512, "Megabytes which must be free in the temp directory for a build to start, 0 disables the check")
+	fs.IntVar(&o.retain, "retain", 2, "Number of builds kept on disk for rollbacks, including the current one")
+	fs.StringVar(&o.upgradeSwitch, "upgrade-switch", "drain", "What happens to WebSocket and other upgraded connections of a replaced instance: drain or close")
+	fs.DurationVar(&o.drainGrace, "drain-grace", 30*time.Second, "How long a replaced instance may finish requests before it is killed")
+
+	fs.BoolVar(&o.dryRun, "dry-run", false, "Build, start and health check pushed heads on the other side without ever switching traffic to them")
+
+	fs.DurationVar(&o.pollInterval, "poll", 0, "Interval to poll GitHub for the head of the branch, for servers webhooks can't reach, 0 disables")
+
+	fs.StringVar(&o.deployWindow, "deploy-window", "", "Cron expression of the minutes deployments may start in, pushes outside are held")
+
+	fs.StringVar(&o.redeploySchedule, "redeploy-schedule", "", "Cron expression or descriptor like @daily of when to rebuild and redeploy the current head")
+	fs.StringVar(&o.restartSchedule, "restart-schedule", "", "Cron expression or descriptor like @daily of when to restart the instance of the current build")
+
+	fs.StringVar(&o.secretsProvider, "secrets-provider", "", "Where -secret-ref and -secret-env are fetched from at startup: vault, aws or gcp, through their command line tools")
+	fs.DurationVar(&o.secretsRefresh, "secrets-refresh", 0, "Interval the fetched secrets are refreshed in, 0 fetches them once")
+	fs.StringVar(&o.gcpProject, "gcp-project", "", "GCP project of the secrets with -secrets-provider=gcp, default is gcloud's")
+
+	fs.IntVar(&o.githubRetries, "github-retries", 4, "Retries of failed GitHub API requests, with exponential backoff")
+
+	fs.StringVar(&o.webhookRepos, "webhook-repos", "", "Comma separated repositories like acme/api or acme/* whose pushes the webhook accepts, for organization webhooks, default is any")
+	fs.Int64Var(&o.webhookMaxBody, "webhook-max-body", webhook.DefaultMaxBody, "Largest push event body accepted in bytes")
+
+	fs.DurationVar(&o.pluginTimeout, "plugin-timeout", 30*time.Second, "How long a -plugin may run")
+
+	fs.StringVar(&o.eventHookSecret, "event-hook-secret", "", "Key of the HMAC-SHA256 in X-Watcher-Signature of -event-hook posts, default is -secret")
+
+	fs.StringVar(&o.slackWebhook, "slack-webhook", "", "Slack incoming webhook URL deployment events are posted to")
+	fs.StringVar(&o.discordWebhook, "discord-webhook", "", "Discord webhook URL deployment events are posted to")
+	fs.StringVar(&o.telegramToken, "telegram-token", "", "Telegram bot token deployment events are sent with to -telegram-chat")
+	fs.StringVar(&o.telegramChat, "telegram-chat", "", "Telegram chat id deployment events are sent to")
+	fs.StringVar(&o.publicURL, "public-url", "", "Base URL of the watcher linked to in notifications, like https://example.com")
+
+	fs.StringVar(&o.smtpAddr, "smtp-addr", "", "SMTP server host:port failure mails are sent through")
+	fs.StringVar(&o.smtpUser, "smtp-user", "", "SMTP user, mails are sent unauthenticated when empty")
+	fs.StringVar(&o.smtpPassword, "smtp-password", "", "SMTP password of -smtp-user")
+	fs.StringVar(&o.mailFrom, "mail-from", "", "Sender of failure mails")
+	fs.StringVar(&o.mailTo, "mail-to", "", "Comma separated recipients of mails about failed deploys and crash loops")
+
+	fs.StringVar(&o.otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector URL deployments are traced to, like http://localhost:4318")
+	fs.StringVar(&o.otlpService, "otlp-service", "watcher", "Service name of exported spans")
+	fs.BoolVar(&o.traceProxied, "trace-requests", false, "Also trace proxied requests, passing traceparent on to the app")
+
+	fs.StringVar(&o.journalPath, "journal", "", "File every deployment attempt is appended to as a JSON line, served by /_deployments")
+
+	fs.StringVar(&o.statePath, "state", "", "File the deployment state is kept in to resume after a restart, builds are kept on exit then")
+	fs.StringVar(&o.pidFile, "pidfile", "", "File the process id is written to, locked while the watcher runs")
+	fs.StringVar(&o.shutdown, "shutdown", "", "What happens to instances when the watcher exits: stop-child drains and stops them, leave-running or handoff to the next watcher through -state, default is handoff with -state and stop-child otherwise")
+
+	fs.StringVar(&o.builderKind, "builder", "go", "How checkouts are built: go, make producing $BINARY, docker building an image tagged with the commit, script, or none for projects -run starts from source")
+	fs.StringVar(&o.makeTarget, "make-target", "", "Target built with -builder=make, default is make's default")
+	fs.StringVar(&o.dockerImage, "docker-image", "", "Image built with -builder=docker and tagged with the commit, default is the binary name")
+	fs.StringVar(&o.buildScript, "build-script", "", "Shell script run with -builder=script, $BINARY and $SHA are set")
+	fs.StringVar(&o.installCmd, "install", "", "Shell command installing dependencies before the build, like \"npm ci\", $BINARY and $SHA are set")
+
+	fs.StringVar(&o.sshHosts, "ssh-hosts", "", "Comma separated [user@]host the build is rolled out to over SSH one after another before it starts locally")
+	fs.StringVar(&o.sshDir, "ssh-dir", "watcher", "Directory on -ssh-hosts builds are copied to, below the home directory unless absolute")
+	fs.StringVar(&o.sshStart, "ssh-start", "cd {{.Dir}} && (nohup ./{{.Binary}} -hostport=:{{.Port}} >run.log 2>&1 &)", "Template of the command starting an instance on a host, with {{.Binary}}, {{.Port}}, {{.Dir}}, {{.Sha}} and {{.Host}}")
+	fs.StringVar(&o.sshStop, "ssh-stop", "pkill -x {{.Binary}} || true", "Template of the command stopping the instance on a host, with the -ssh-start placeholders")
+	fs.IntVar(&o.sshPort, "ssh-port", 8080, "Port instances on -ssh-hosts listen on, probed at -health-path")
+	fs.StringVar(&o.sshIdentity, "ssh-identity", "", "Private key file ssh and scp use, default is ssh's")
+
+	fs.BoolVar(&o.dockerPush, "docker-push", false, "Push images built with -builder=docker, -docker-image names the registry like registry.example.com/app")
+	fs.BoolVar(&o.pushOnly, "push-only", false, "Only build and push images with -builder=docker, nothing is started locally")
+	fs.StringVar(&o.registryUser, "registry-user", "", "User logged in to the registry of -docker-image before pushing")
+	fs.StringVar(&o.registryPassword, "registry-password", "", "Password or token of -registry-user")
+
+	fs.StringVar(&o.k8sDeployment, "k8s-deployment", "", "Kubernetes Deployment whose image is set to each build, instead of starting it locally")
+	fs.StringVar(&o.k8sNamespace, "k8s-namespace", "", "Namespace of -k8s-deployment, default is kubectl's")
+	fs.StringVar(&o.k8sContainer, "k8s-container", "*", "Container of -k8s-deployment whose image is set, * sets all")
+	fs.StringVar(&o.k8sImage, "k8s-image", "{{.Image}}:{{.Sha}}", "Template of the image rolled out, with {{.Image}}, -docker-image or the binary name, and {{.Sha}}; -builder=docker pushes it first")
+	fs.DurationVar(&o.k8sTimeout, "k8s-timeout", 5*time.Minute, "How long a rollout may take to complete")
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Kubeconfig file kubectl uses, default is kubectl's")
+
+	fs.StringVar(&o.leasePath, "lease", "", "Lease file on storage shared by redundant watchers: its holder deploys first, the others follow one at a time")
+	fs.DurationVar(&o.leaseTTL, "lease-ttl", 15*time.Second, "How long -lease is held without renewal")
+	fs.StringVar(&o.instanceName, "instance-id", "", "Name of this watcher among those sharing -lease, default is the host name")
+
+	fs.StringVar(&o.watchDir, "watch-dir", "", "Local source directory rebuilt and swapped in on every change instead of GitHub commits, for development")
+	fs.DurationVar(&o.watchDebounce, "watch-debounce", 300*time.Millisecond, "How long changes to -watch-dir have to settle before a rebuild")
+
+	fs.StringVar(&o.vcsKind, "vcs", "git", "How commits are checked out: git runs the git binary, go-git needs none but can't verify signatures")
+	fs.BoolVar(&o.submodules, "submodules", false, "Clone and update git submodules of the repo")
+	fs.DurationVar(&o.buildTimeout, "build-timeout", 10*time.Minute, "Timeout for the whole clone and build of a single deploy")
+
+	fs.Var(&o.requestHeaders, "request-header", "Header rule for proxied requests, \"Name: value\" sets, \"+Name: value\" adds and \"-Name\" removes, may be repeated")
+	fs.Var(&o.responseHeaders, "response-header", "Header rule for proxied responses like -request-header, may be repeated")
+	fs.Var(&o.routeList, "route", "Route as /prefix=target sending requests below prefix to a static upstream URL or to app, the deployed app which also gets everything unrouted, may be repeated")
+	fs.Var(&o.appList, "app", "App as space separated name=, repo=, binary=, branch=, ports= and hosts= fields, requests are routed to it by host, may be repeated")
+	fs.Var(&o.eventHooks, "event-hook", "URL deployment lifecycle events are posted to as signed JSON, may be repeated")
+	fs.Var(&o.pluginList, "plugin", "Command run at every deployment event with the event hook JSON on stdin, at pre_build and pre_switch a non-zero exit vetoes the deployment, may be repeated")
+	fs.Var(&o.secretRefs, "secret-ref", "Secret flag fetched from -secrets-provider as name=ref, like secret=kv/watcher#webhook, may be repeated")
+	fs.Var(&o.secretEnvRefs, "secret-env", "Environment variable of builds and instances fetched from -secrets-provider as NAME=ref, may be repeated")
+	fs.Var(&o.allowList, "allow", "Allow requests below /prefix only from the clients in /prefix=CIDR,..., github stands for GitHub's webhook ranges, may be repeated")
+
+	o.secretFiles = map[string]*string{}
+	for _, name := range secretFlags {
+		o.secretFiles[name] = fs.String(name+"-file", "", "File holding the value of -"+name)
+	}
+}
+
+// stringList is a flag which may be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// listenFdsEnv tells a re-executed watcher how many listeners it inherited,
+// they start at file descriptor 3.
+const listenFdsEnv = "WATCHER_LISTEN_FDS"
+
+// inherited reports whether the watcher was started by an upgrading parent.
+func inherited() bool {
+	return os.Getenv(listenFdsEnv) != ""
+}
+
+// listen returns a listener for each of addrs. Listeners handed over by an
+// upgrading parent or activated by systemd are reused in the same order,
+// systemd sockets named "http" and "https" are matched by name. Addresses
+// beyond the sockets systemd activated are listened on.
+func listen(addrs ...string) ([]net.Listener, error) {
+	if inherited() {
+		n, err := strconv.Atoi(os.Getenv(listenFdsEnv))
+		if err != nil {
+			return nil, errors.Wrap(err, "parse "+listenFdsEnv)
+		}
+
+		return fileListeners(n, nil, addrs)
+	}
+
+	n, err := systemdFds()
+	if err != nil {
+		return nil, err
+	}
+
+	var ls []net.Listener
+	if n > 0 {
+		names := systemdNames()
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+
+		k := len(addrs)
+		if n < k {
+			k = n
+		}
+		if ls, err = fileListeners(n, names, addrs[:k]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, addr := range addrs[len(ls):] {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "listen on %s", addr)
+		}
+
+		ls = append(ls, l)
+	}
+
+	return ls, nil
+}
+
+// fileListeners turns the n descriptors starting at 3 into listeners for
+// addrs. names optionally names the descriptors after the trimmed ports of
+// addrs, e.g. "https" for ":https".
+func fileListeners(n int, names, addrs []string) ([]net.Listener, error) {
+	if n != len(addrs) {
+		return nil, errors.Errorf("inherited %d listeners, want %d", n, len(addrs))
+	}
+
+	fds := make([]int, len(addrs))
+	for i := range fds {
+		fds[i] = 3 + i
+	}
+
+	if len(names) == n {
+		for i, addr := range addrs {
+			for j, name := range names {
+				if name == strings.TrimPrefix(addr, ":") {
+					fds[i] = 3 + j
+				}
+			}
+		}
+	}
+
+	ls := make([]net.Listener, n)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), addrs[i])
+
+		l, err := net.FileListener(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "inherit listener of %s", addrs[i])
+		}
+		f.Close()
+
+		ls[i] = l
+	}
+
+	return ls, nil
+}
+
+// upgrade starts a new watcher from the current executable with the same
+// arguments, handing ls over to it. The new watcher sends SIGTERM to this
+// one once it serves. Windows can't hand listeners over.
+func upgrade(ls []net.Listener) error {
+	files := make([]*os.File, len(ls))
+	for i, l := range ls {
+		tl, ok := l.(*net.TCPListener)
+		if !ok {
+			return errors.Errorf("listener %s can't be handed over", l.Addr())
+		}
+
+		f, err := tl.File()
+		if err != nil {
+			return errors.Wrap(err, "listener file")
+		}
+		defer f.Close()
+
+		files[i] = f
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "executable")
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), listenFdsEnv+"="+strconv.Itoa(len(files)))
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "start new watcher")
+	}
+
+	return nil
+}
+
+// handedOver tells systemd and the upgrading parent that this watcher serves
+// now.
+func handedOver() error {
+	if !inherited() {
+		return sdNotify("READY=1")
+	}
+
+	if err := sdNotify("MAINPID=" + strconv.Itoa(os.Getpid()) + "\nREADY=1"); err != nil {
+		return err
+	}
+
+	if err := stopParent(); err != nil {
+		return errors.Wrap(err, "signal parent watcher")
+	}
+
+	return nil
+}
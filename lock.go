@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/romanyx/watcher/deployer"
+)
+
+var errLocked = errors.New("locked by another watcher")
+
+// locks are the files locked by this watcher. They are kept open, and so
+// locked, until it exits.
+var locks []*os.File
+
+// takeLock locks the file at path and writes the process id into it. A
+// file locked by another watcher is reported with its process id, or
+// waited for with wait.
+func takeLock(path string, wait bool) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open lock")
+	}
+
+	if err := lockFile(f, wait); err != nil {
+		f.Close()
+
+		if err == errLocked {
+			pid, _ := ioutil.ReadFile(path)
+			return errors.Errorf("%s is locked by watcher pid %s", path, strings.TrimSpace(string(pid)))
+		}
+
+		return errors.Wrapf(err, "lock %s", path)
+	}
+
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return errors.Wrap(err, "truncate lock")
+	}
+
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return errors.Wrap(err, "write lock")
+	}
+
+	locks = append(locks, f)
+
+	return nil
+}
+
+// writePidFile writes and locks -pidfile.
+func writePidFile(wait bool) error {
+	if opts.pidFile == "" {
+		return nil
+	}
+
+	return takeLock(opts.pidFile, wait)
+}
+
+// removePidFile removes -pidfile on exit.
+func removePidFile() {
+	if opts.pidFile != "" {
+		os.Remove(opts.pidFile)
+	}
+}
+
+// lockApps locks the build directory of every app, so two watchers never
+// build the same binary into it or fight over its ports.
+func lockApps(specs []deployer.App, wait bool) error {
+	for _, spec := range specs {
+		dir := filepath.Join(os.TempDir(), spec.Binary)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrap(err, "create build directory")
+		}
+
+		if err := takeLock(filepath.Join(dir, ".watcher.lock"), wait); err != nil {
+			return errors.Wrapf(err, "app %s", spec.Name)
+		}
+	}
+
+	return nil
+}
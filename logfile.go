@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// logFile is the -log file. It is rotated once it grows past -log-max-size
+// or gets older than -log-max-age, keeping -log-retain rotated files, and
+// can be reopened after an external tool such as logrotate moved it.
+type logFile struct {
+	path string
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	opened  time.Time
+	maxSize int64
+	maxAge  time.Duration
+	retain  int
+}
+
+func openLogFile(path string, maxSize int64, maxAge time.Duration, retain int) (*logFile, error) {
+	l := &logFile{path: path, maxSize: maxSize, maxAge: maxAge, retain: retain}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// open opens the file at l.path for appending. The caller must hold l.mu
+// unless l is not shared yet.
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open log file")
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "stat log file")
+	}
+
+	l.f = f
+	l.size = info.Size()
+	l.opened = time.Now()
+
+	return nil
+}
+
+// reopen opens l.path again and closes the file written so far.
+func (l *logFile) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	old := l.f
+	if err := l.open(); err != nil {
+		return err
+	}
+
+	return old.Close()
+}
+
+func (l *logFile) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.due(len(b)) {
+		if err := l.rotate(); err != nil {
+			// Keep logging to the old file rather than losing lines.
+			os.Stderr.WriteString(err.Error() + "\n")
+		}
+	}
+
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+
+	return n, err
+}
+
+// due reports whether the file has to be rotated before writing n bytes.
+func (l *logFile) due(n int) bool {
+	if l.size == 0 {
+		return false
+	}
+
+	if l.maxSize > 0 && l.size+int64(n) > l.maxSize {
+		return true
+	}
+
+	return l.maxAge > 0 && time.Since(l.opened) > l.maxAge
+}
+
+// rotate moves the file aside with a timestamp suffix, opens a new one and
+// removes rotated files beyond the retention. The caller must hold l.mu.
+func (l *logFile) rotate() error {
+	rotated := l.path + "." + time.Now().Format("20060102-150405.000")
+	if err := os.Rename(l.path, rotated); err != nil {
+		return errors.Wrap(err, "rotate log file")
+	}
+
+	old := l.f
+	if err := l.open(); err != nil {
+		return err
+	}
+	old.Close()
+
+	rotatedFiles, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return errors.Wrap(err, "list rotated log files")
+	}
+
+	// Timestamp suffixes sort oldest first.
+	sort.Strings(rotatedFiles)
+	for len(rotatedFiles) > l.retain {
+		if err := os.Remove(rotatedFiles[0]); err != nil {
+			return errors.Wrap(err, "remove rotated log file")
+		}
+		rotatedFiles = rotatedFiles[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// subWatcher is the subsystem tagged on log lines of the watcher itself.
+const subWatcher = "watcher"
+
+// setupLogging sets the default logger to write -log-format lines of at
+// least -log-level to out.
+func setupLogging(out io.Writer) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(opts.logLevel)); err != nil {
+		return errors.Wrap(err, "parse log level")
+	}
+
+	ho := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch strings.ToLower(opts.logFormat) {
+	case "text":
+		h = slog.NewTextHandler(out, ho)
+	case "json":
+		h = slog.NewJSONHandler(out, ho)
+	default:
+		return errors.Errorf("unknown log format %q", opts.logFormat)
+	}
+
+	slog.SetDefault(slog.New(h))
+
+	return nil
+}
+
+// logger returns the logger of subsystem.
+func logger(subsystem string) *slog.Logger {
+	return slog.Default().With("subsystem", subsystem)
+}
+
+// fatal logs msg as an error and exits.
+func fatal(msg string, args ...interface{}) {
+	logger(subWatcher).Error(msg, args...)
+	os.Exit(1)
+}
@@ -1,348 +1,182 @@
 package main
 
 import (
-	"crypto/hmac"
-	"crypto/sha1"
-	"crypto/tls"
-	"encoding/hex"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
-	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
-	"os/exec"
-	"os/signal"
-	"path/filepath"
-	"strconv"
-	"sync"
-	"syscall"
+	"time"
 
-	"github.com/julienschmidt/httprouter"
-	"github.com/pkg/errors"
-	"golang.org/x/crypto/acme/autocert"
+	"github.com/romanyx/watcher/deployer"
+	"github.com/romanyx/watcher/proxy"
 )
 
-var (
-	hostPort   = flag.String("hostport", "localhost:8080", "server host and port")
-	repoName   = flag.String("repo", "", "Repo name")
-	domainName = flag.String("domain", "", "Domain name")
-	logPath    = flag.String("log", "", "Log file path, default is output")
-	secret     = flag.String("secret", "", "Github notification secret")
-	binary     = flag.String("binary", "default-name", "Builded binary name")
-)
+// apps are the apps managed by the watcher.
+var apps *deployer.Set
 
 func main() {
-	flag.Parse()
-
-	if *logPath != "" {
-		logOut, err := os.Open(*logPath)
-
-		if err != nil {
-			log.Fatal(err)
+	// "watcher run" and plain "watcher" start the server, the other
+	// subcommands talk to a running one.
+	if len(os.Args) > 1 {
+		if os.Args[1] == "run" {
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		} else if _, ok := commands[os.Args[1]]; ok {
+			os.Exit(runSubcommand(os.Args[1], os.Args[2:]))
 		}
-
-		log.SetOutput(logOut)
 	}
 
-	if *repoName == "" {
-		log.Fatal("Specify repo name using flag -repo=")
-	}
+	flag.Parse()
 
-	if *secret == "" {
-		log.Fatal("Specify secret using flag -secret=")
+	if opts.showVersion {
+		fmt.Println(watcherBuild())
+		return
 	}
 
-	if *domainName == "" {
-		log.Fatal("Specify domain using flag -domain=")
+	// Settings come from the command line, then the environment, then
+	// the config file.
+	if err := opts.load(flag.CommandLine); err != nil {
+		log.Fatal(err)
 	}
 
-	r := httprouter.New()
-
-	p := NewProxy(r, *repoName, *binary)
-	err := p.firstBuild()
-
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	p.router.POST("/_github_push", httprouter.Handle(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		body, err := ioutil.ReadAll(r.Body)
+	var logOut io.Writer = os.Stderr
+	var lf *logFile
+	if opts.logPath != "" {
+		var err error
+		lf, err = openLogFile(opts.logPath, opts.logMaxSize<<20, opts.logMaxAge, opts.logRetain)
 		if err != nil {
-			log.Printf("Request to /_github_push read body: %s", err)
-			return
-		}
-
-		h := hmac.New(sha1.New, []byte(*secret))
-		h.Write(body)
-		sign := fmt.Sprintf("sha1=%s", hex.EncodeToString(h.Sum(nil)))
-
-		if !hmac.Equal([]byte(r.Header.Get("X-Hub-Signature")), []byte(sign)) {
-			// TODO(romanyx): ban it then
-			log.Printf("Wrong signature from %s", r.RemoteAddr)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		pushEvnt := struct {
-			Ref  string `json:"ref"`
-			Head string `json:"after"`
-		}{}
-
-		if err := json.Unmarshal(body, &pushEvnt); err != nil {
-			log.Printf("Request to /_github_push unmarshal: %s", err)
-			return
-		}
-
-		if pushEvnt.Ref == "refs/heads/master" {
-			fmt.Fprintf(w, "Thanks, updating to %s now", pushEvnt.Head)
-			go p.changeSide(pushEvnt.Head)
-			return
+			log.Fatal(err)
 		}
-
-		fmt.Fprintf(w, "Unnecessary inform, head %s", p.last)
-	}))
-
-	p.router.GET("/_status", httprouter.Handle(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		fmt.Fprintf(w, "side=%d\nhead=%s\ndir=%s\nport=808%d", p.side, p.last, p.dir, p.side)
-	}))
-
-	p.router.GET("/", httprouter.Handle(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		p.proxy.ServeHTTP(w, r)
-	}))
-
-	m := &autocert.Manager{
-		Cache:      autocert.DirCache("."),
-		Prompt:     autocert.AcceptTOS,
-		HostPolicy: autocert.HostWhitelist(*domainName),
+		logOut = lf
 	}
 
-	go http.ListenAndServe(":http", m.HTTPHandler(nil))
-
-	srv := &http.Server{
-		Addr:    ":https",
-		Handler: p.router,
-		TLSConfig: &tls.Config{
-			GetCertificate: m.GetCertificate,
-		},
+	if err := setupLogging(logOut); err != nil {
+		log.Fatal(err)
 	}
-	go srv.ListenAndServeTLS("", "")
 
-	ch := make(chan os.Signal)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
-
-	log.Println(<-ch)
-
-	err = p.clearPrevious()
-
-	if err != nil {
-		log.Fatalln(err)
+	if opts.otlpEndpoint != "" {
+		deployer.EnableTracing(opts.otlpEndpoint, opts.otlpService)
 	}
-}
-
-// Proxy is a struct to manage a traffic flow
-type Proxy struct {
-	proxy  *httputil.ReverseProxy
-	router *httprouter.Router
 
-	repo, binn string
-
-	mu        sync.Mutex
-	last, dir string
-	side      int
-	cmd       *exec.Cmd
-}
-
-// NewProxy returns initialized proxy
-func NewProxy(r *httprouter.Router, repo, binn string) *Proxy {
-	return &Proxy{side: 2, router: r, repo: repo, binn: binn}
-}
-
-// ServeHTTTP handler
-func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	p.router.ServeHTTP(w, r)
-}
-
-func (p *Proxy) clearPrevious() error {
-	if p.dir != "" {
-		err := os.RemoveAll(p.dir)
-
-		if err != nil {
-			return errors.Wrap(err, "removing previous directory")
-		}
+	if err := checkSecretRefs(); err != nil {
+		fatal("Invalid secret reference", "err", err)
 	}
 
-	return nil
-}
-
-func (p *Proxy) changeSide(head string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	nSide := 1
-	if p.side == 1 {
-		nSide = 2
+	fetchCtx, cancelFetch := context.WithTimeout(context.Background(), time.Minute)
+	err := fetchSecrets(fetchCtx)
+	cancelFetch()
+	if err == nil {
+		err = applySecretRefs(flag.CommandLine)
 	}
-
-	dir := filepath.Join(os.TempDir(), p.binn, strconv.Itoa(nSide))
-
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			log.Println(errors.Wrap(err, "temp dir creation"))
-			return
-		}
-	} else {
-		err = os.RemoveAll(dir)
-
-		if err != nil {
-			log.Println(errors.Wrap(err, "temp dir remove previous"))
-			return
-		}
+	if err != nil {
+		fatal("Fetch secrets failed", "err", err)
 	}
 
-	cloneCmd := exec.Command("git", "clone", fmt.Sprintf("https://github.com/%v", p.repo), ".")
-	cloneCmd.Stdout = os.Stdout
-	cloneCmd.Stderr = os.Stdout
-	cloneCmd.Dir = dir
-	if err := cloneCmd.Run(); err != nil {
-		log.Println(errors.Wrap(err, "git clone"))
-		return
-	}
+	plain := checkFlags()
 
-	fetchCmd := exec.Command("git", "fetch")
-	fetchCmd.Stdout = os.Stdout
-	fetchCmd.Stderr = os.Stdout
-	fetchCmd.Dir = dir
-	if err := fetchCmd.Run(); err != nil {
-		log.Println(errors.Wrap(err, "git fetch"))
-		return
+	// A second watcher fails here, before it touches ports, builds or
+	// state. An upgraded one takes the locks once its parent is gone.
+	if !inherited() {
+		if err := writePidFile(false); err != nil {
+			fatal("Another watcher is running", "err", err)
+		}
 	}
 
-	resetCmd := exec.Command("git", "reset", "--hard", head)
-	resetCmd.Stdout = os.Stdout
-	resetCmd.Stderr = os.Stdout
-	resetCmd.Dir = dir
-	if err := resetCmd.Run(); err != nil {
-		log.Println(errors.Wrap(err, "git reset"))
-		return
+	specs, err := deployer.ParseApps(opts.appList, deployer.App{
+		Name:    opts.binary,
+		Repo:    opts.repoName,
+		Binary:  opts.binary,
+		Branch:  opts.branch,
+		Ports:   opts.ports,
+		State:   opts.statePath,
+		Journal: opts.journalPath,
+	})
+	if err != nil {
+		fatal("Invalid -app", "err", err)
 	}
 
-	cleanCmd := exec.Command("git", "clean", "-f", "-d", "-x")
-	cleanCmd.Stdout = os.Stdout
-	cleanCmd.Stderr = os.Stdout
-	cleanCmd.Dir = dir
-	if err := cleanCmd.Run(); err != nil {
-		log.Println(errors.Wrap(err, "git clean"))
-		return
+	if !inherited() {
+		if err := lockApps(specs, false); err != nil {
+			fatal("Another watcher is running", "err", err)
+		}
 	}
 
-	getCmd := exec.Command("go", "get", "-d")
-	getCmd.Stdout = os.Stdout
-	getCmd.Stderr = os.Stdout
-	getCmd.Dir = dir
-	if err := getCmd.Run(); err != nil {
-		log.Println(errors.Wrap(err, "go get"))
-		return
+	addrs := []string{opts.httpAddr, opts.httpsAddr}
+	if plain {
+		addrs = addrs[:1]
 	}
-
-	buildCmd := exec.Command("go", "build", "-o", p.binn)
-	buildCmd.Stdout = os.Stdout
-	buildCmd.Stderr = os.Stdout
-	buildCmd.Dir = dir
-	if err := buildCmd.Run(); err != nil {
-		log.Println(errors.Wrap(err, "go build -o"))
-		return
+	// The admin listener comes last, it is handed over on upgrades like
+	// the others.
+	if opts.adminAddr != "" {
+		addrs = append(addrs, adminListenAddr(opts.adminAddr))
 	}
 
-	lCmd := p.cmd
-
-	runCmd := exec.Command(fmt.Sprintf("./%s", p.binn), "-hostport=localhost:808"+strconv.Itoa(nSide))
-	runCmd.Stdout = os.Stdout
-	runCmd.Stderr = os.Stdout
-	runCmd.Dir = dir
-
-	go runCmd.Run()
-	p.cmd = runCmd
-
-	u, err := url.Parse(fmt.Sprintf("http://localhost:808%v/", strconv.Itoa(nSide)))
-
-	p.proxy = httputil.NewSingleHostReverseProxy(u)
-
+	ls, err := listen(addrs...)
 	if err != nil {
-		log.Println(errors.Wrap(err, "url parse for proxying"))
-		return
+		fatal("Listen failed", "err", err)
 	}
 
-	if lCmd != nil {
-		if err = lCmd.Process.Kill(); err != nil {
-			log.Println(errors.Wrap(err, "kill previous command"))
-			return
+	if opts.accessLogPath != "" {
+		if accessLog, err = proxy.OpenAccessLog(opts.accessLogPath, opts.accessLogFormat, trustedNets); err != nil {
+			fatal("Open access log failed", "err", err)
 		}
 	}
 
-	err = p.clearPrevious()
-
-	if err != nil {
-		log.Println(errors.Wrap(err, "remove previous directory"))
-		return
-	}
-
-	p.side = nSide
-	p.dir = dir
-	p.last = head
-
-	log.Printf("Project was rebuilded head now is %s", p.last)
-}
-
-func (p *Proxy) firstBuild() error {
-	current, err := getCurrent()
-	if err != nil {
-		return errors.Wrap(err, "get current")
+	if apps, err = deployer.NewSet(opts.settings()); err != nil {
+		fatal("Invalid settings", "err", err)
 	}
+	apps.Reload = logReload
+	apps.Notify = func(state string) { sdNotify(state) }
 
-	ok := p.last == current
-	p.last = current
-
-	if ok {
-		return nil
+	for _, spec := range specs {
+		if err := apps.Add(spec); err != nil {
+			fatal("Invalid -app", "err", err)
+		}
 	}
 
-	p.changeSide(current)
-
-	return nil
-}
-
-func getCurrent() (hash string, err error) {
-	resp, err := http.Get(fmt.Sprintf("https://api.github.com/repos/%v/commits/master", *repoName))
-
-	if err != nil {
-		return "", errors.Wrap(err, "get request")
+	// An upgraded watcher's parent may still be using its builds.
+	if err := apps.Start(!inherited()); err != nil {
+		fatal("Start failed", "err", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("get request %v", resp.Status)
+	ctx, cancel := context.WithCancel(context.Background())
+	if opts.secretsRefresh > 0 {
+		go refreshSecrets(ctx)
 	}
 
-	defer resp.Body.Close()
+	// Requests are answered during the first builds, with the
+	// maintenance page until an instance runs.
+	servers := serve(ls, plain)
 
-	body, err := ioutil.ReadAll(resp.Body)
+	go func() {
+		if err := apps.Follow(); err != nil {
+			fatal("Start failed", "err", err)
+		}
+	}()
 
-	if err != nil {
-		return "", errors.Wrap(err, "read body")
+	if err := handedOver(); err != nil {
+		logger(subWatcher).Error("Hand over failed", "err", err)
 	}
 
-	sha := struct {
-		Sha string `json:"sha"`
-	}{}
+	if inherited() {
+		go func() {
+			if err := writePidFile(true); err != nil {
+				logger(subWatcher).Error("Take over pid file failed", "err", err)
+			}
+			if err := lockApps(specs, true); err != nil {
+				logger(subWatcher).Error("Take over app locks failed", "err", err)
+			}
+		}()
+	}
 
-	err = json.Unmarshal(body, &sha)
+	upgraded := waitSignals(ls, lf)
+	cancel()
 
-	if err != nil {
-		return "", errors.Wrap(err, "unmarshal json")
+	if !upgraded {
+		sdNotify("STOPPING=1")
+		removePidFile()
 	}
 
-	return sha.Sha, nil
+	stop(servers, upgraded)
 }
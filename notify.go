@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// systemdFds returns the number of sockets passed by systemd socket
+// activation, zero when the watcher was not socket activated.
+func systemdFds() (int, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return 0, errors.Wrap(err, "parse LISTEN_FDS")
+	}
+
+	return n, nil
+}
+
+// systemdNames returns the socket names of LISTEN_FDNAMES, which order the
+// activated sockets by FileDescriptorName.
+func systemdNames() []string {
+	if os.Getenv("LISTEN_FDNAMES") == "" {
+		return nil
+	}
+
+	return strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+}
+
+// sdNotify sends state to systemd when running as a notify service. It does
+// nothing otherwise.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return errors.Wrap(err, "dial notify socket")
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return errors.Wrap(err, "write notify socket")
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals handled by the watcher: sigReload reloads the settings,
+// sigReopen reopens -log and sigUpgrade hands over to a new watcher. The
+// others shut down.
+var (
+	sigReload  os.Signal = syscall.SIGHUP
+	sigReopen  os.Signal = syscall.SIGUSR1
+	sigUpgrade os.Signal = syscall.SIGUSR2
+
+	watchedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2}
+)
+
+// lockFile takes an exclusive lock on f, waiting for it with wait. It
+// returns errLocked when f is locked elsewhere and wait is not set.
+func lockFile(f *os.File, wait bool) error {
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+
+	err := syscall.Flock(int(f.Fd()), how)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+
+	return err
+}
+
+// stopParent asks the watcher which started this one to exit.
+func stopParent() error {
+	return syscall.Kill(os.Getppid(), syscall.SIGTERM)
+}
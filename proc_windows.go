@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// Windows has no signals to reload, reopen the log or upgrade with, use
+// POST /_reload instead. Ctrl-C, Ctrl-Break and closing the console shut
+// down.
+var (
+	sigReload  os.Signal
+	sigReopen  os.Signal
+	sigUpgrade os.Signal
+
+	watchedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+var (
+	kernel32       = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx = kernel32.NewProc("LockFileEx")
+)
+
+// lockFile takes an exclusive lock on f, waiting for it with wait. It
+// returns errLocked when f is locked elsewhere and wait is not set. The
+// locked byte lies past the content, which stays readable.
+func lockFile(f *os.File, wait bool) error {
+	flags := uintptr(lockfileExclusiveLock)
+	if !wait {
+		flags |= lockfileFailImmediately
+	}
+
+	ol := new(syscall.Overlapped)
+	ol.OffsetHigh = 1
+
+	r, _, err := procLockFileEx.Call(f.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return errLocked
+	}
+
+	return err
+}
+
+// stopParent asks the watcher which started this one to exit. Handing
+// listeners over is not supported on Windows, so there is none.
+func stopParent() error {
+	return errors.New("upgrades are not supported on Windows")
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AccessLog writes a line per proxied request.
+type AccessLog struct {
+	mu      sync.Mutex
+	out     io.Writer
+	format  string
+	trusted Trusted
+}
+
+// OpenAccessLog opens path for appending access log lines in format,
+// "common", "combined" or "json". Client addresses are taken from the
+// forwarding headers of trusted.
+func OpenAccessLog(path, format string, trusted Trusted) (*AccessLog, error) {
+	switch format {
+	case "common", "combined", "json":
+	default:
+		return nil, errors.Errorf("unknown access log format %q", format)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open access log")
+	}
+
+	return &AccessLog{out: f, format: format, trusted: trusted}, nil
+}
+
+// Wrap logs the requests served by h.
+func (l *AccessLog) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &StatusWriter{ResponseWriter: w}
+
+		h.ServeHTTP(sw, r)
+
+		l.log(r, sw, start, time.Since(start))
+	})
+}
+
+func (l *AccessLog) log(r *http.Request, sw *StatusWriter, start time.Time, latency time.Duration) {
+	status := sw.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	ip := l.trusted.ClientIP(r)
+
+	var line []byte
+	switch l.format {
+	case "json":
+		line, _ = json.Marshal(struct {
+			Time      time.Time `json:"time"`
+			ClientIP  string    `json:"client_ip"`
+			Method    string    `json:"method"`
+			Path      string    `json:"path"`
+			Proto     string    `json:"proto"`
+			Status    int       `json:"status"`
+			Bytes     int64     `json:"bytes"`
+			LatencyMs float64   `json:"latency_ms"`
+			Referer   string    `json:"referer,omitempty"`
+			UserAgent string    `json:"user_agent,omitempty"`
+		}{start, ip, r.Method, r.URL.RequestURI(), r.Proto, status, sw.Bytes,
+			float64(latency) / float64(time.Millisecond), r.Referer(), r.UserAgent()})
+	default:
+		size := "-"
+		if sw.Bytes > 0 {
+			size = fmt.Sprint(sw.Bytes)
+		}
+
+		line = []byte(fmt.Sprintf("%s - - [%s] %q %d %s", ip, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.URL.RequestURI()+" "+r.Proto, status, size))
+
+		if l.format == "combined" {
+			line = append(line, fmt.Sprintf(" %q %q", r.Referer(), r.UserAgent())...)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.out.Write(append(line, '\n'))
+}
+
+// StatusWriter records the status code and body size of a response.
+type StatusWriter struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int64
+}
+
+func (w *StatusWriter) WriteHeader(status int) {
+	if w.Status == 0 {
+		w.Status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	if w.Status == 0 {
+		w.Status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.Bytes += int64(n)
+
+	return n, err
+}
+
+func (w *StatusWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *StatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
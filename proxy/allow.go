@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// allowRule limits requests to paths starting with prefix to clients in
+// nets, or in GitHub's hook ranges when github is set.
+type allowRule struct {
+	prefix string
+	nets   []*net.IPNet
+	github bool
+}
+
+// Allowlist limits paths to clients.
+type Allowlist struct {
+	rules   []allowRule
+	trusted Trusted
+	hooks   *HookRanges
+}
+
+// HookRanges are GitHub's webhook ranges which allow rules may name as
+// github. Until they are set requests limited to them are denied.
+type HookRanges struct {
+	mu   sync.RWMutex
+	nets []*net.IPNet
+}
+
+// Set replaces the ranges.
+func (h *HookRanges) Set(nets []*net.IPNet) {
+	h.mu.Lock()
+	h.nets = nets
+	h.mu.Unlock()
+}
+
+func (h *HookRanges) contains(ip net.IP) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return contains(h.nets, ip)
+}
+
+// ParseAllowRules parses "/prefix=CIDR,CIDR" rules, "github" may be listed
+// among the CIDRs for the ranges in hooks. Client addresses are taken from
+// the forwarding headers of trusted.
+func ParseAllowRules(list []string, trusted Trusted, hooks *HookRanges) (*Allowlist, error) {
+	a := &Allowlist{trusted: trusted, hooks: hooks}
+	for _, s := range list {
+		i := strings.Index(s, "=")
+		if i < 1 || s[0] != '/' {
+			return nil, errors.Errorf("allow rule %q: want /prefix=CIDR,...", s)
+		}
+
+		rule := allowRule{prefix: s[:i]}
+		var cidrs []string
+		for _, c := range strings.Split(s[i+1:], ",") {
+			if strings.TrimSpace(c) == "github" {
+				rule.github = true
+				continue
+			}
+			cidrs = append(cidrs, c)
+		}
+
+		nets, err := ParseCIDRs(strings.Join(cidrs, ","))
+		if err != nil {
+			return nil, errors.Wrapf(err, "allow rule %q", s)
+		}
+		rule.nets = nets
+
+		a.rules = append(a.rules, rule)
+	}
+
+	return a, nil
+}
+
+// rule returns the rule with the longest prefix matching path.
+func (a *Allowlist) rule(path string) *allowRule {
+	var best *allowRule
+	for i := range a.rules {
+		r := &a.rules[i]
+		if strings.HasPrefix(path, r.prefix) && (best == nil || len(r.prefix) > len(best.prefix)) {
+			best = r
+		}
+	}
+
+	return best
+}
+
+// allowed reports whether ip may request path.
+func (a *Allowlist) allowed(path string, ip net.IP) bool {
+	r := a.rule(path)
+	if r == nil {
+		return true
+	}
+
+	if ip == nil {
+		return false
+	}
+
+	if contains(r.nets, ip) {
+		return true
+	}
+
+	return r.github && a.hooks != nil && a.hooks.contains(ip)
+}
+
+// UsesGithub reports whether any rule refers to GitHub's hook ranges.
+func (a *Allowlist) UsesGithub() bool {
+	for _, r := range a.rules {
+		if r.github {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wrap denies requests from clients not allowed by a with 403.
+func (a *Allowlist) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := a.trusted.ClientIP(r)
+		if !a.allowed(r.URL.Path, net.ParseIP(ip)) {
+			logger().Warn("Request not allowed", "path", r.URL.Path, "ip", ip)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CacheRule sets Cache-Control to value for paths matching pattern. A
+// pattern ending in "/" matches every path below it, others are matched
+// with path.Match.
+type CacheRule struct {
+	pattern, value string
+}
+
+// ParseCacheRules parses "pattern=value" rules separated by ";".
+func ParseCacheRules(s string) ([]CacheRule, error) {
+	var rules []CacheRule
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		i := strings.Index(item, "=")
+		if i <= 0 {
+			return nil, errors.Errorf("cache control rule %q: want pattern=value", item)
+		}
+
+		rule := CacheRule{pattern: strings.TrimSpace(item[:i]), value: strings.TrimSpace(item[i+1:])}
+		if _, err := path.Match(rule.pattern, "/"); err != nil {
+			return nil, errors.Wrapf(err, "cache control rule %q", item)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (c CacheRule) match(p string) bool {
+	if strings.HasSuffix(c.pattern, "/") {
+		return strings.HasPrefix(p, c.pattern)
+	}
+
+	ok, _ := path.Match(c.pattern, p)
+	return ok
+}
+
+// CacheControl sets Cache-Control on responses of h by the first of rules
+// matching the request path.
+func CacheControl(rules []CacheRule, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rule := range rules {
+			if rule.match(r.URL.Path) {
+				w = &headerWriter{ResponseWriter: w, set: func(h http.Header) {
+					h.Set("Cache-Control", rule.value)
+				}}
+				break
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// headerWriter calls set on the response headers right before they are
+// written.
+type headerWriter struct {
+	http.ResponseWriter
+	set         func(http.Header)
+	wroteHeader bool
+}
+
+func (w *headerWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.set(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
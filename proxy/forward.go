@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Trusted are the proxies in front of the watcher. Forwarding headers set
+// by them are passed on and tell the address of the client.
+type Trusted []*net.IPNet
+
+// ParseCIDRs parses a comma separated list of CIDRs, single addresses are
+// taken as /32 or /128.
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse cidr")
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// contains reports whether ip is in one of nets.
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RemoteIP returns the address of the peer of r.
+func RemoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// ClientIP returns the address of the client of r. When r comes from a
+// trusted proxy it is taken from X-Forwarded-For, walking it from the right
+// to the first address which is not a trusted proxy: entries left of that
+// are made up by the client.
+func (t Trusted) ClientIP(r *http.Request) string {
+	ip := RemoteIP(r)
+	if ip == nil {
+		return r.RemoteAddr
+	}
+
+	if !contains(t, ip) {
+		return ip.String()
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	client := ip.String()
+	for i := len(hops) - 1; i >= 0; i-- {
+		hopIP := net.ParseIP(hops[i])
+		if hopIP == nil {
+			// Not an address, what the trusted proxy saw is unknown.
+			return hops[i]
+		}
+
+		client = hopIP.String()
+		if !contains(t, hopIP) {
+			break
+		}
+	}
+
+	return client
+}
+
+// Forward sets X-Forwarded-Proto, X-Forwarded-Host and Forwarded on r
+// before it is proxied. Forwarding headers sent by clients which are not
+// trusted proxies are dropped first. X-Forwarded-For is appended to by the
+// reverse proxy itself.
+func (t Trusted) Forward(r *http.Request) {
+	ip := RemoteIP(r)
+
+	if ip == nil || !contains(t, ip) {
+		r.Header.Del("X-Forwarded-For")
+		r.Header.Del("X-Forwarded-Proto")
+		r.Header.Del("X-Forwarded-Host")
+		r.Header.Del("Forwarded")
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	if r.Header.Get("X-Forwarded-Proto") == "" {
+		r.Header.Set("X-Forwarded-Proto", proto)
+	}
+
+	if r.Header.Get("X-Forwarded-Host") == "" {
+		r.Header.Set("X-Forwarded-Host", r.Host)
+	}
+
+	node := "unknown"
+	if ip != nil {
+		node = ip.String()
+		if ip.To4() == nil {
+			node = `"[` + node + `]"`
+		}
+	}
+
+	forwarded := "for=" + node + ";proto=" + proto + ";host=" + quoteForwarded(r.Host)
+	if prior := r.Header.Get("Forwarded"); prior != "" {
+		forwarded = prior + ", " + forwarded
+	}
+	r.Header.Set("Forwarded", forwarded)
+}
+
+// quoteForwarded quotes v as a Forwarded header value when needed.
+func quoteForwarded(v string) string {
+	if strings.ContainsAny(v, ":[]\" ;,") {
+		return `"` + strings.Replace(v, `"`, `\"`, -1) + `"`
+	}
+
+	return v
+}
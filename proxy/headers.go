@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// HeaderRule changes one header: "Name: value" sets it, "+Name: value"
+// adds a value and "-Name" removes it.
+type HeaderRule struct {
+	op          byte
+	name, value string
+}
+
+// ParseHeaderRules parses header rules like "X-Frame-Options: DENY".
+func ParseHeaderRules(list []string) ([]HeaderRule, error) {
+	var rules []HeaderRule
+	for _, s := range list {
+		rule := HeaderRule{op: '='}
+		if s != "" && (s[0] == '+' || s[0] == '-') {
+			rule.op, s = s[0], s[1:]
+		}
+
+		if rule.op == '-' {
+			rule.name = strings.TrimSpace(s)
+		} else {
+			i := strings.Index(s, ":")
+			if i < 0 {
+				return nil, errors.Errorf("header rule %q: want Name: value", s)
+			}
+			rule.name = strings.TrimSpace(s[:i])
+			rule.value = strings.TrimSpace(s[i+1:])
+		}
+
+		if rule.name == "" {
+			return nil, errors.Errorf("header rule %q: empty name", s)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func applyHeaderRules(rules []HeaderRule, h http.Header) {
+	for _, rule := range rules {
+		switch rule.op {
+		case '+':
+			h.Add(rule.name, rule.value)
+		case '-':
+			h.Del(rule.name)
+		default:
+			h.Set(rule.name, rule.value)
+		}
+	}
+}
+
+// RewriteHeaders applies req rules to requests and resp rules to responses
+// of h.
+func RewriteHeaders(req, resp []HeaderRule, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		applyHeaderRules(req, r.Header)
+
+		if len(resp) > 0 {
+			w = &headerWriter{ResponseWriter: w, set: func(h http.Header) {
+				applyHeaderRules(resp, h)
+			}}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
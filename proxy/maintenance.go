@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaintenancePage is the template of the maintenance page without
+// one of its own.
+const DefaultMaintenancePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.RetryAfter}}">
+<title>Be right back</title>
+</head>
+<body>
+<h1>Be right back</h1>
+<p>The site is being updated, please retry in {{.RetryAfter}} seconds.</p>
+</body>
+</html>
+`
+
+var defaultPage = template.Must(template.New("maintenance").Parse(DefaultMaintenancePage))
+
+// Maintenance is the page served while no instance is able to handle
+// requests.
+type Maintenance struct {
+	// Page is executed with {{.RetryAfter}}, DefaultMaintenancePage
+	// when nil.
+	Page *template.Template
+
+	// RetryAfter are the seconds clients are asked to wait.
+	RetryAfter int
+}
+
+// LoadMaintenancePage reads the template of a maintenance page from path.
+func LoadMaintenancePage(path string) (*template.Template, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read maintenance page")
+	}
+
+	t, err := template.New("maintenance").Parse(string(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse maintenance page")
+	}
+
+	return t, nil
+}
+
+// ServeHTTP answers r with the maintenance page and 503.
+func (m Maintenance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	page := m.Page
+	if page == nil {
+		page = defaultPage
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(m.RetryAfter))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	err := page.Execute(w, struct{ RetryAfter int }{m.RetryAfter})
+	if err != nil {
+		logger().Error("Execute maintenance page failed", "err", err)
+	}
+}
@@ -0,0 +1,12 @@
+// Package proxy holds the request path in front of deployed apps: the
+// transports to instances and upstreams, forwarding headers, routes to
+// static upstreams, header and Cache-Control rules, compression, access
+// logs, client allowlists and the maintenance page.
+package proxy
+
+import "log/slog"
+
+// logger returns the logger of the proxy subsystem.
+func logger() *slog.Logger {
+	return slog.Default().With("subsystem", "proxy")
+}
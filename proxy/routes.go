@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Route sends requests below a path prefix to a handler.
+type Route struct {
+	prefix  string
+	handler http.Handler
+}
+
+// ParseRoutes parses "prefix=target" routes, target being a static
+// upstream URL, proxied to through upstream, or "app" for the deployed app.
+func ParseRoutes(list []string, app http.Handler, upstream func(*url.URL) http.Handler) ([]Route, error) {
+	var routes []Route
+	for _, s := range list {
+		i := strings.Index(s, "=")
+		if i <= 0 || !strings.HasPrefix(s, "/") {
+			return nil, errors.Errorf("route %q: want /prefix=target", s)
+		}
+
+		r := Route{prefix: s[:i]}
+
+		target := s[i+1:]
+		if target == "app" {
+			r.handler = app
+		} else {
+			u, err := url.Parse(target)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return nil, errors.Errorf("route %q: target must be app or an absolute URL", s)
+			}
+			r.handler = upstream(u)
+		}
+
+		routes = append(routes, r)
+	}
+
+	// Longest prefix wins.
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+
+	return routes, nil
+}
+
+// Upstream proxies to a static upstream u over t, passing on the
+// forwarding headers trusted by trusted.
+func Upstream(u *url.URL, t http.RoundTripper, trusted Trusted) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.Transport = t
+
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		trusted.Forward(r)
+		director(r)
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		logger().Error("Proxy failed", "upstream", u.String(), "err", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
+
+	return proxy
+}
+
+// match reports whether path is prefix or below it.
+func (r Route) match(path string) bool {
+	if !strings.HasPrefix(path, r.prefix) {
+		return false
+	}
+
+	return len(path) == len(r.prefix) || strings.HasSuffix(r.prefix, "/") || path[len(r.prefix)] == '/'
+}
+
+// RouteByPrefix serves requests by the route matching their path, the
+// others by fallback.
+func RouteByPrefix(routes []Route, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rt := range routes {
+			if rt.match(r.URL.Path) {
+				rt.handler.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		fallback.ServeHTTP(w, r)
+	})
+}
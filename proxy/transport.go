@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Transport holds the timeouts and limits of connections to instances and
+// upstreams, and how instances are spoken to.
+type Transport struct {
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	MaxIdleConnsPerHost   int
+
+	// Proto is http, h2c for HTTP/2 without TLS or https, which is
+	// verified against CA unless Insecure is set.
+	Proto    string
+	CA       string
+	Insecure bool
+}
+
+// New returns a transport with the timeouts and limits of t.
+func (t Transport) New() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+
+	d := &net.Dialer{Timeout: t.DialTimeout, KeepAlive: 30 * time.Second}
+	tr.DialContext = d.DialContext
+	tr.TLSHandshakeTimeout = t.TLSHandshakeTimeout
+	tr.ResponseHeaderTimeout = t.ResponseHeaderTimeout
+	tr.IdleConnTimeout = t.IdleConnTimeout
+	tr.MaxIdleConnsPerHost = t.MaxIdleConnsPerHost
+
+	return tr
+}
+
+// Backend returns the transport to an instance speaking t.Proto, dialing
+// addr on network. It also returns the URL scheme to use.
+func (t Transport) Backend(network, addr string) (*http.Transport, string, error) {
+	tr := t.New()
+	scheme := "http"
+
+	if network == "unix" {
+		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: t.DialTimeout}
+			return d.DialContext(ctx, "unix", addr)
+		}
+	}
+
+	switch t.Proto {
+	case "http":
+	case "h2c":
+		// Prior knowledge HTTP/2 without TLS, as gRPC servers speak it.
+		tr.Protocols = new(http.Protocols)
+		tr.Protocols.SetUnencryptedHTTP2(true)
+	case "https":
+		scheme = "https"
+		tr.ForceAttemptHTTP2 = true
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: t.Insecure}
+
+		if t.CA != "" {
+			pem, err := ioutil.ReadFile(t.CA)
+			if err != nil {
+				return nil, "", errors.Wrap(err, "read backend ca")
+			}
+
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, "", errors.Errorf("no certificates in %s", t.CA)
+			}
+			tr.TLSClientConfig.RootCAs = pool
+		}
+	default:
+		return nil, "", errors.Errorf("unknown backend protocol %q", t.Proto)
+	}
+
+	return tr, scheme, nil
+}
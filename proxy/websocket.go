@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// IsUpgrade reports whether r asks to switch protocols, e.g. to WebSocket.
+func IsUpgrade(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), "upgrade") {
+			return r.Header.Get("Upgrade") != ""
+		}
+	}
+
+	return false
+}
+
+// Upgrades tracks client connections hijacked for upgraded protocols, so
+// they can be closed when their backend is replaced.
+type Upgrades struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (u *Upgrades) add(c net.Conn) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.conns == nil {
+		u.conns = make(map[net.Conn]struct{})
+	}
+	u.conns[c] = struct{}{}
+}
+
+func (u *Upgrades) remove(c net.Conn) {
+	u.mu.Lock()
+	delete(u.conns, c)
+	u.mu.Unlock()
+}
+
+// Track returns w recording the connection it hands out when it is
+// hijacked for an upgraded request.
+func (u *Upgrades) Track(w http.ResponseWriter) http.ResponseWriter {
+	return &trackingWriter{ResponseWriter: w, upgrades: u}
+}
+
+// CloseAll closes all tracked connections.
+func (u *Upgrades) CloseAll() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for c := range u.conns {
+		c.Close()
+	}
+}
+
+// trackingWriter records the connection hijacked by the reverse proxy for
+// an upgraded request.
+type trackingWriter struct {
+	http.ResponseWriter
+	upgrades *Upgrades
+}
+
+func (w *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tc := &trackedConn{Conn: conn, upgrades: w.upgrades}
+	w.upgrades.add(tc)
+
+	return tc, rw, nil
+}
+
+func (w *trackingWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *trackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type trackedConn struct {
+	net.Conn
+	upgrades *Upgrades
+	once     sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.upgrades.remove(c)
+	})
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// reloadable are the flags a reload applies. Other settings are bound to
+// listeners, files or state opened at startup and need a restart.
+var reloadable = map[string]bool{
+	"branch": true, "vcs": true, "builder": true, "make-target": true, "docker-image": true, "build-script": true, "install": true, "submodules": true, "build-timeout": true,
+	"ssh-hosts": true, "ssh-dir": true, "ssh-start": true, "ssh-stop": true, "ssh-port": true, "ssh-identity": true,
+	"docker-push": true, "registry-user": true, "registry-password": true, "registry-password-file": true,
+	"k8s-deployment": true, "k8s-namespace": true, "k8s-container": true, "k8s-image": true, "k8s-timeout": true, "kubeconfig": true,
+	"require-signed": true, "allowed-signers": true, "gpg-home": true,
+	"github-token": true, "github-token-file": true, "github-retries": true,
+	"wait-ci": true, "ci-checks": true, "ci-timeout": true, "ci-interval": true,
+	"deploy-window": true, "redeploy-schedule": true, "restart-schedule": true, "dry-run": true, "poll": true, "approval": true, "approve-timeout": true,
+	"canary": true, "canary-header": true, "canary-cookie": true,
+	"health-path": true, "health-timeout": true, "health-interval": true, "health-threshold": true,
+	"retain": true, "min-free": true, "build-limits": true, "run-limits": true, "drain-grace": true,
+	"rollback-window": true, "rollback-error-rate": true, "rollback-min-requests": true, "rollback-health-failures": true,
+	"max-restarts": true, "restart-backoff": true, "max-restart-backoff": true,
+	"slack-webhook": true, "discord-webhook": true, "public-url": true,
+	"telegram-token": true, "telegram-token-file": true, "telegram-chat": true,
+	"smtp-addr": true, "smtp-user": true, "smtp-password": true, "smtp-password-file": true,
+	"mail-from": true, "mail-to": true,
+	"event-hook": true, "plugin": true, "plugin-timeout": true, "webhook-max-body": true, "webhook-repos": true, "event-hook-secret": true, "event-hook-secret-file": true,
+	"allow": true, "request-header": true, "response-header": true,
+	"route": true, "cache-control": true, "gzip": true,
+	"read-auth": true, "read-auth-file": true, "control-auth": true, "control-auth-file": true,
+	"secret": true, "secret-file": true,
+}
+
+// reloadMu serializes reloads.
+var reloadMu sync.Mutex
+
+// reload reads the command line, the environment, the config file and the
+// secret files into new options and applies their reloadable settings to
+// all apps. The flags of the running watcher are left alone, as are
+// listeners and running instances. It returns the changed settings which
+// need a restart to take effect.
+func reload() ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	o := &options{}
+	fs := flag.NewFlagSet(flag.CommandLine.Name(), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o.register(fs)
+
+	err := fs.Parse(os.Args[1:])
+	if err == nil {
+		err = o.load(fs)
+	}
+	if err == nil {
+		err = applySecretRefs(fs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Settings which need a restart keep their running values.
+	var restart []string
+	flag.VisitAll(func(running *flag.Flag) {
+		if reloadable[running.Name] {
+			return
+		}
+
+		f := fs.Lookup(running.Name)
+		if f.Value.String() != running.Value.String() {
+			restart = append(restart, f.Name)
+		}
+		if err == nil {
+			err = setFlag(f, running.Value)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := apps.Configure(o.settings()); err != nil {
+		return nil, err
+	}
+
+	return restart, nil
+}
+
+// setFlag sets f to the value of v, all values of a repeatable flag.
+func setFlag(f *flag.Flag, v flag.Value) error {
+	if l, ok := v.(*stringList); ok {
+		*f.Value.(*stringList) = append(stringList(nil), *l...)
+		return nil
+	}
+
+	return f.Value.Set(v.String())
+}
+
+// logReload reloads and logs the outcome.
+func logReload() ([]string, error) {
+	restart, err := reload()
+	if err != nil {
+		logger(subWatcher).Error("Reload failed", "err", err)
+		return nil, err
+	}
+
+	sort.Strings(restart)
+	if len(restart) > 0 {
+		logger(subWatcher).Warn("Reloaded, some settings need a restart", "settings", strings.Join(restart, ","))
+	} else {
+		logger(subWatcher).Info("Reloaded")
+	}
+
+	return restart, nil
+}
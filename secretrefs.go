@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/romanyx/watcher/secrets"
+)
+
+// fetchedSecrets holds the values last fetched for -secret-ref, by flag
+// name, and -secret-env, as NAME=value.
+var fetchedSecrets struct {
+	mu    sync.RWMutex
+	flags map[string]string
+	env   []string
+}
+
+// splitSecretRef splits a "name=ref" rule of -secret-ref or -secret-env.
+func splitSecretRef(rule string) (string, string, error) {
+	i := strings.Index(rule, "=")
+	if i < 1 || i == len(rule)-1 {
+		return "", "", errors.Errorf("secret reference %q: want name=ref", rule)
+	}
+
+	return rule[:i], rule[i+1:], nil
+}
+
+// checkSecretRefs checks that -secret-ref names secret flags only.
+func checkSecretRefs() error {
+	for _, rule := range opts.secretRefs {
+		name, _, err := splitSecretRef(rule)
+		if err != nil {
+			return err
+		}
+
+		if !isSecretFlag(name) {
+			return errors.Errorf("-%s can't be fetched, only %s", name, strings.Join(secretFlags, ", "))
+		}
+	}
+
+	for _, rule := range opts.secretEnvRefs {
+		if _, _, err := splitSecretRef(rule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fetchSecrets fetches -secret-ref and -secret-env from -secrets-provider.
+// The values fetched before are kept when any fetch fails.
+func fetchSecrets(ctx context.Context) error {
+	if len(opts.secretRefs) == 0 && len(opts.secretEnvRefs) == 0 {
+		return nil
+	}
+
+	pr, err := secrets.New(opts.secretsProvider, secrets.Config{Project: opts.gcpProject})
+	if err != nil {
+		return err
+	}
+
+	flags := map[string]string{}
+	for _, rule := range opts.secretRefs {
+		name, ref, _ := splitSecretRef(rule)
+		if flags[name], err = pr.Get(ctx, ref); err != nil {
+			return errors.Wrapf(err, "fetch -%s", name)
+		}
+	}
+
+	var env []string
+	for _, rule := range opts.secretEnvRefs {
+		name, ref, _ := splitSecretRef(rule)
+		v, err := pr.Get(ctx, ref)
+		if err != nil {
+			return errors.Wrapf(err, "fetch $%s", name)
+		}
+		env = append(env, name+"="+v)
+	}
+
+	fetchedSecrets.mu.Lock()
+	fetchedSecrets.flags, fetchedSecrets.env = flags, env
+	fetchedSecrets.mu.Unlock()
+
+	return nil
+}
+
+// isSecretFlag reports whether name is one of secretFlags.
+func isSecretFlag(name string) bool {
+	for _, s := range secretFlags {
+		if s == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applySecretRefs sets the flags of -secret-ref on fs to their fetched
+// values. They must not be set otherwise.
+func applySecretRefs(fs *flag.FlagSet) error {
+	fetchedSecrets.mu.RLock()
+	defer fetchedSecrets.mu.RUnlock()
+
+	for name, v := range fetchedSecrets.flags {
+		f := fs.Lookup(name)
+		if f.Value.String() != "" {
+			return errors.Errorf("both -%s and -secret-ref %s= are set", name, name)
+		}
+
+		if err := f.Value.Set(v); err != nil {
+			return errors.Wrapf(err, "set -%s", name)
+		}
+	}
+
+	return nil
+}
+
+// secretEnv returns the variables fetched for -secret-env.
+func secretEnv() []string {
+	fetchedSecrets.mu.RLock()
+	defer fetchedSecrets.mu.RUnlock()
+
+	return fetchedSecrets.env
+}
+
+// refreshSecrets fetches the secrets again every -secrets-refresh until
+// ctx is done and reloads to pass them on to the apps. It must be started
+// once.
+func refreshSecrets(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(opts.secretsRefresh):
+		case <-ctx.Done():
+			return
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		err := fetchSecrets(fetchCtx)
+		cancel()
+		if err != nil {
+			logger(subWatcher).Error("Refresh secrets failed", "err", err)
+			continue
+		}
+
+		if _, err := reload(); err != nil {
+			logger(subWatcher).Error("Apply refreshed secrets failed", "err", err)
+			continue
+		}
+
+		logger(subWatcher).Info("Secrets refreshed")
+	}
+}
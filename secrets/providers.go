@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"context"
+)
+
+// Vault reads secrets with the vault binary, VAULT_ADDR and VAULT_TOKEN
+// or its token helper configure it. A ref is "path#field" of a KV secret,
+// the field defaults to value.
+type Vault struct{}
+
+// Get returns the field of the KV secret at the path of ref.
+func (Vault) Get(ctx context.Context, ref string) (string, error) {
+	path, key := splitRef(ref)
+	if key == "" {
+		key = "value"
+	}
+
+	return output(ctx, "vault", "kv", "get", "-field="+key, path)
+}
+
+// AWS reads secrets from AWS Secrets Manager with the aws binary, which
+// takes its credentials and region from the usual places. A ref is the
+// secret id or ARN, "#key" picks a key of a JSON secret.
+type AWS struct{}
+
+// Get returns the string value of the secret of ref.
+func (AWS) Get(ctx context.Context, ref string) (string, error) {
+	id, key := splitRef(ref)
+
+	secret, err := output(ctx, "aws", "secretsmanager", "get-secret-value", "--secret-id", id, "--query", "SecretString", "--output", "text")
+	if err != nil {
+		return "", err
+	}
+
+	return field(secret, key)
+}
+
+// GCP reads secrets from GCP Secret Manager with the gcloud binary, in
+// Project or gcloud's default one. A ref is the secret name, "#key" picks
+// a key of a JSON secret. The latest version is read.
+type GCP struct {
+	Project string
+}
+
+// Get returns the latest version of the secret of ref.
+func (g GCP) Get(ctx context.Context, ref string) (string, error) {
+	name, key := splitRef(ref)
+
+	args := []string{"secrets", "versions", "access", "latest", "--secret=" + name}
+	if g.Project != "" {
+		args = append(args, "--project="+g.Project)
+	}
+
+	secret, err := output(ctx, "gcloud", args...)
+	if err != nil {
+		return "", err
+	}
+
+	return field(secret, key)
+}
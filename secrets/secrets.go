@@ -0,0 +1,95 @@
+// Package secrets fetches secrets from HashiCorp Vault, AWS Secrets Manager
+// or GCP Secret Manager through their command line tools, which bring
+// their usual configuration and credentials.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Provider fetches secrets.
+type Provider interface {
+	// Get returns the secret ref names. The form of ref depends on the
+	// provider, "#key" at its end picks a key of a JSON secret.
+	Get(ctx context.Context, ref string) (string, error)
+}
+
+// Config holds the settings of the providers.
+type Config struct {
+	// Project is the GCP project of the secrets, gcloud's default when
+	// empty.
+	Project string
+}
+
+// New returns the provider named kind: vault, aws or gcp.
+func New(kind string, c Config) (Provider, error) {
+	switch kind {
+	case "vault":
+		return Vault{}, nil
+	case "aws":
+		return AWS{}, nil
+	case "gcp":
+		return GCP{Project: c.Project}, nil
+	}
+
+	return nil, errors.Errorf("unknown secrets provider %q", kind)
+}
+
+// splitRef splits ref into the secret and the key after "#".
+func splitRef(ref string) (string, string) {
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+
+	return ref, ""
+}
+
+// field returns key of the JSON object secret, or secret itself when key
+// is empty.
+func field(secret, key string) (string, error) {
+	if key == "" {
+		return secret, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", errors.Wrap(err, "secret is not a JSON object")
+	}
+
+	v, ok := fields[key]
+	if !ok {
+		return "", errors.Errorf("secret has no key %q", key)
+	}
+
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+
+	body, err := json.Marshal(v)
+	return string(body), err
+}
+
+// output runs the command and returns its output without the final line
+// break. The error carries what it wrote to stderr.
+func output(ctx context.Context, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.Errorf("%s: %v: %s", name, err, msg)
+		}
+		return "", errors.Wrap(err, name)
+	}
+
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/romanyx/watcher/deployer"
+)
+
+// serve serves the apps on the front listeners ls, HTTP and unless plain
+// HTTPS, and the watcher endpoints on the admin listeners.
+func serve(ls []net.Listener, plain bool) []*http.Server {
+	var (
+		tlsConfig   *tls.Config
+		httpHandler http.Handler = apps
+		err         error
+	)
+	if !plain {
+		if tlsConfig, httpHandler, err = frontTLS(); err != nil {
+			fatal("TLS setup failed", "err", err)
+		}
+	}
+
+	httpSrv := &http.Server{
+		Handler:           httpHandler,
+		ReadTimeout:       opts.readTimeout,
+		ReadHeaderTimeout: opts.readHeaderTimeout,
+		WriteTimeout:      opts.writeTimeout,
+		IdleTimeout:       opts.idleTimeout,
+	}
+	go httpSrv.Serve(ls[0])
+
+	servers := []*http.Server{httpSrv}
+
+	if !plain {
+		srv := &http.Server{
+			Handler:           apps,
+			TLSConfig:         tlsConfig,
+			ReadTimeout:       opts.readTimeout,
+			ReadHeaderTimeout: opts.readHeaderTimeout,
+			WriteTimeout:      opts.writeTimeout,
+			IdleTimeout:       opts.idleTimeout,
+		}
+		go srv.ServeTLS(ls[1], "", "")
+		servers = append(servers, srv)
+	}
+
+	if opts.adminSocket != "" {
+		os.Remove(opts.adminSocket)
+		l, err := net.Listen("unix", opts.adminSocket)
+		if err != nil {
+			fatal("Listen on admin socket failed", "err", err)
+		}
+		os.Chmod(opts.adminSocket, 0600)
+
+		admin := adminServer(apps)
+		servers = append(servers, admin)
+		go admin.Serve(l)
+	}
+
+	if opts.adminAddr != "" {
+		admin := adminServer(apps)
+		servers = append(servers, admin)
+		go admin.Serve(ls[len(ls)-1])
+	}
+
+	return servers
+}
+
+// waitSignals handles signals until one shuts the watcher down. It
+// reports whether a new watcher took over the listeners ls.
+func waitSignals(ls []net.Listener, lf *logFile) (upgraded bool) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, watchedSignals...)
+
+	for sig := range ch {
+		if sig == sigReload {
+			go logReload()
+			continue
+		}
+
+		if sig == sigReopen {
+			if lf != nil {
+				if err := lf.reopen(); err != nil {
+					logger(subWatcher).Error("Reopen log failed", "err", err)
+				}
+			}
+			continue
+		}
+
+		if sig != sigUpgrade {
+			logger(subWatcher).Info("Shutting down", "signal", sig.String())
+			break
+		}
+
+		if err := upgrade(ls); err != nil {
+			logger(subWatcher).Error("Upgrade failed", "err", err)
+			continue
+		}
+
+		upgraded = true
+		logger(subWatcher).Info("Started new watcher, waiting for it to take over")
+	}
+
+	return upgraded
+}
+
+// stop shuts servers down and deals with the instances as -shutdown says.
+func stop(servers []*http.Server, upgraded bool) {
+	// -drain-grace may have been reloaded.
+	ctx, cancel := context.WithTimeout(context.Background(), apps.Config().DrainGrace)
+	defer cancel()
+
+	// No new requests are taken while the instances are dealt with.
+	for _, s := range servers {
+		s.Shutdown(ctx)
+	}
+
+	// Deployments in progress are cancelled and clean up after
+	// themselves before the instances are dealt with.
+	deployer.StopDeploys()
+	deployer.FlushTracing()
+
+	// The new watcher runs its own instances, or adopts ours from the
+	// state file.
+	policy := shutdownPolicy()
+	if upgraded {
+		policy = shutdownStop
+		if !ownsBuilds() {
+			policy = shutdownHandoff
+		}
+	}
+
+	switch policy {
+	case shutdownStop:
+		apps.Stop()
+	case shutdownLeave:
+		logger(subWatcher).Info("Leaving instances running")
+		return
+	case shutdownHandoff:
+		logger(subWatcher).Info("Leaving instances running for the next watcher")
+		return
+	}
+
+	if !ownsBuilds() {
+		return
+	}
+
+	if err := apps.ClearBuilds(); err != nil {
+		fatal("Clear previous build failed", "err", err)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/romanyx/watcher/deployer"
+	"github.com/romanyx/watcher/proxy"
+)
+
+// trustedNets are the -trusted-proxies, maintenance is the page served
+// while no instance is up and accessLog the -access-log. They are set up
+// once at startup.
+var (
+	trustedNets proxy.Trusted
+	maintenance proxy.Maintenance
+	accessLog   *proxy.AccessLog
+)
+
+// settings returns the settings of the apps from o.
+func (o *options) settings() *deployer.Config {
+	return &deployer.Config{
+		Secret:      o.secret,
+		ReadAuth:    o.readAuthSpec,
+		ControlAuth: o.controlAuthSpec,
+		AdminOnly:   o.adminAddr != "",
+
+		BanThreshold:   o.banThreshold,
+		BanDuration:    o.banDuration,
+		PushRate:       o.pushRate,
+		WebhookRepos:   o.webhookRepos,
+		WebhookMaxBody: o.webhookMaxBody,
+
+		RequireSigned:  o.requireSigned,
+		GPGHome:        o.gpgHome,
+		AllowedSigners: o.allowedSigners,
+
+		GithubToken:   o.githubToken,
+		GithubRetries: o.githubRetries,
+		WaitCI:        o.waitCI,
+		CIChecks:      o.ciChecks,
+		CITimeout:     o.ciTimeout,
+		CIInterval:    o.ciInterval,
+
+		VCS:              o.vcsKind,
+		Submodules:       o.submodules,
+		Builder:          o.builderKind,
+		MakeTarget:       o.makeTarget,
+		DockerImage:      o.dockerImage,
+		BuildScript:      o.buildScript,
+		Install:          o.installCmd,
+		BuildTimeout:     o.buildTimeout,
+		BuildLimits:      o.buildLimits,
+		RunLimits:        o.runLimits,
+		Cgroup:           o.cgroupRoot,
+		MinFree:          o.minFree,
+		Retain:           o.retain,
+		DockerPush:       o.dockerPush,
+		PushOnly:         o.pushOnly,
+		RegistryUser:     o.registryUser,
+		RegistryPassword: o.registryPassword,
+		WatchDir:         o.watchDir,
+		WatchDebounce:    o.watchDebounce,
+		LogsDir:          o.logsDir,
+		LogsRetain:       o.logsRetain,
+
+		Env:   childEnv(),
+		Build: watcherBuild(),
+
+		SSHHosts:      o.sshHosts,
+		SSHDir:        o.sshDir,
+		SSHStart:      o.sshStart,
+		SSHStop:       o.sshStop,
+		SSHIdentity:   o.sshIdentity,
+		SSHPort:       o.sshPort,
+		K8sDeployment: o.k8sDeployment,
+		K8sNamespace:  o.k8sNamespace,
+		K8sContainer:  o.k8sContainer,
+		K8sImage:      o.k8sImage,
+		Kubeconfig:    o.kubeconfig,
+		K8sTimeout:    o.k8sTimeout,
+
+		Lease:      o.leasePath,
+		InstanceID: o.instanceName,
+		LeaseTTL:   o.leaseTTL,
+
+		Run:             o.runTmpl,
+		RunDir:          o.runDirTmpl,
+		PortEnv:         o.portEnv,
+		Socket:          o.socketMode,
+		Replicas:        o.replicaCount,
+		HealthPath:      o.healthPath,
+		HealthTimeout:   o.healthTimeout,
+		HealthInterval:  o.healthInterval,
+		HealthThreshold: o.healthThreshold,
+		Transport: proxy.Transport{
+			DialTimeout:           o.dialTimeout,
+			TLSHandshakeTimeout:   o.tlsHandshakeTimeout,
+			ResponseHeaderTimeout: o.responseHeaderTimeout,
+			IdleConnTimeout:       o.idleConnTimeout,
+			MaxIdleConnsPerHost:   o.maxIdleConnsPerHost,
+			Proto:                 o.backendProto,
+			CA:                    o.backendCA,
+			Insecure:              o.backendInsecure,
+		},
+		UpgradeSwitch:     o.upgradeSwitch,
+		DrainGrace:        o.drainGrace,
+		MaxRestarts:       o.maxRestarts,
+		RestartBackoff:    o.restartBackoff,
+		MaxRestartBackoff: o.maxRestartBackoff,
+
+		RollbackWindow:         o.rollbackWindow,
+		RollbackErrorRate:      o.rollbackErrorRate,
+		RollbackMinRequests:    o.rollbackMinRequests,
+		RollbackHealthFailures: o.rollbackHealthFailures,
+
+		Canary:           o.canaryPercent,
+		CanaryHeader:     o.canaryHeader,
+		CanaryCookie:     o.canaryCookie,
+		Sticky:           o.stickyMode,
+		Approval:         o.approval,
+		DryRun:           o.dryRun,
+		ApproveTimeout:   o.approveTimeout,
+		Poll:             o.pollInterval,
+		DeployWindow:     o.deployWindow,
+		RedeploySchedule: o.redeploySchedule,
+		RestartSchedule:  o.restartSchedule,
+
+		EventHooks:      o.eventHooks,
+		Plugins:         o.pluginList,
+		EventHookSecret: o.eventHookSecret,
+		PluginTimeout:   o.pluginTimeout,
+		SlackWebhook:    o.slackWebhook,
+		DiscordWebhook:  o.discordWebhook,
+		TelegramToken:   o.telegramToken,
+		TelegramChat:    o.telegramChat,
+		PublicURL:       o.publicURL,
+		SMTPAddr:        o.smtpAddr,
+		SMTPUser:        o.smtpUser,
+		SMTPPassword:    o.smtpPassword,
+		MailFrom:        o.mailFrom,
+		MailTo:          o.mailTo,
+
+		Routes:          o.routeList,
+		Allow:           o.allowList,
+		RequestHeaders:  o.requestHeaders,
+		ResponseHeaders: o.responseHeaders,
+		CacheControl:    o.cacheRules,
+		Gzip:            o.gzipEnabled,
+		TraceRequests:   o.traceProxied,
+		MetricsPath:     o.metricsPath,
+		AllowRefresh:    o.allowRefresh,
+		Hold:            o.hold,
+		Trusted:         trustedNets,
+		Maintenance:     maintenance,
+		AccessLog:       accessLog,
+	}
+}
+
+// checkFlags checks the flags main needs and sets up what the apps share.
+// It reports whether the watcher serves plain HTTP only.
+func checkFlags() (plain bool) {
+	if opts.watchDir != "" {
+		if len(opts.appList) > 0 {
+			fatal("Flag -watch-dir can't be combined with -app")
+		}
+
+		dir, err := filepath.Abs(opts.watchDir)
+		if err != nil {
+			fatal("Invalid -watch-dir", "err", err)
+		}
+		opts.watchDir = dir
+
+		if opts.repoName == "" {
+			opts.repoName = filepath.Base(dir)
+		}
+	}
+
+	if opts.repoName == "" && len(opts.appList) == 0 {
+		fatal("Specify repo name using flag -repo= or apps using -app=")
+	}
+
+	if opts.secret == "" && opts.watchDir == "" {
+		fatal("Specify secret using flag -secret=")
+	}
+
+	// -watch-dir serves plain HTTP on -http-addr unless TLS is set up.
+	plain = len(acmeDomains()) == 0 && opts.tlsCert == ""
+	if plain && opts.watchDir == "" {
+		fatal("Specify domains using flag -acme-domains= or certificate using flags -tls-cert= and -tls-key=")
+	}
+
+	maintenance.RetryAfter = opts.retryAfter
+	if opts.maintenancePath != "" {
+		page, err := proxy.LoadMaintenancePage(opts.maintenancePath)
+		if err != nil {
+			fatal("Invalid -maintenance-page", "err", err)
+		}
+		maintenance.Page = page
+	}
+
+	nets, err := proxy.ParseCIDRs(opts.trustedProxies)
+	if err != nil {
+		fatal("Invalid -trusted-proxies", "err", err)
+	}
+	trustedNets = nets
+
+	if err := checkShutdown(); err != nil {
+		fatal("Invalid -shutdown", "err", err)
+	}
+
+	return plain
+}
@@ -0,0 +1,45 @@
+package main
+
+import "github.com/pkg/errors"
+
+// Shutdown policies for instances, see -shutdown.
+const (
+	shutdownStop    = "stop-child"
+	shutdownLeave   = "leave-running"
+	shutdownHandoff = "handoff"
+)
+
+// shutdownPolicy returns what -shutdown asks for, or the default.
+func shutdownPolicy() string {
+	if opts.shutdown != "" {
+		return opts.shutdown
+	}
+
+	if ownsBuilds() {
+		return shutdownStop
+	}
+
+	return shutdownHandoff
+}
+
+// checkShutdown validates -shutdown. A handoff needs -state for the next
+// watcher to find the instances.
+func checkShutdown() error {
+	switch shutdownPolicy() {
+	case shutdownStop, shutdownLeave:
+		return nil
+	case shutdownHandoff:
+		if opts.statePath == "" {
+			return errors.New("-shutdown=handoff needs -state")
+		}
+		return nil
+	}
+
+	return errors.Errorf("unknown -shutdown %q", opts.shutdown)
+}
+
+// ownsBuilds reports whether build directories belong to this run only and
+// are removed on exit. They are kept for the next run with -state.
+func ownsBuilds() bool {
+	return opts.statePath == ""
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// frontTLS returns the TLS config of the front listener and the handler of
+// the plain HTTP one. With -tls-cert and -tls-key the given certificate is
+// served and plain HTTP redirects to HTTPS, otherwise certificates for
+// -acme-domains are obtained and renewed from Let's Encrypt.
+func frontTLS() (*tls.Config, http.Handler, error) {
+	if opts.tlsCert != "" || opts.tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(opts.tlsCert, opts.tlsKey)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "load tls certificate")
+		}
+
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, http.HandlerFunc(redirectHTTPS), nil
+	}
+
+	domains := acmeDomains()
+	if len(domains) == 0 {
+		return nil, nil, errors.New("no certificate or acme domains configured")
+	}
+
+	m := &autocert.Manager{
+		Cache:      autocert.DirCache(opts.acmeCache),
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      opts.acmeEmail,
+	}
+
+	return &tls.Config{GetCertificate: m.GetCertificate}, m.HTTPHandler(nil), nil
+}
+
+// redirectHTTPS redirects r to the same URL on the HTTPS listener.
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if _, port, err := net.SplitHostPort(opts.httpsAddr); err == nil && port != "" && port != "443" && port != "https" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// acmeDomains returns the domains of -acme-domains and the older -domain.
+func acmeDomains() []string {
+	var domains []string
+	for _, d := range strings.Split(opts.acmeDomainList+","+opts.domainName, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+
+	return domains
+}
@@ -0,0 +1,57 @@
+package vcs
+
+import (
+	"context"
+
+	"github.com/romanyx/watcher/builder"
+)
+
+// Git checks out with the git binary.
+type Git struct{}
+
+// gitStep is a git command and the kind of error its failure means.
+type gitStep struct {
+	step builder.Step
+	kind error
+}
+
+// Checkout clones o.URL into dir and resets it to o.Head.
+func (Git) Checkout(ctx context.Context, dir string, o Options) error {
+	clone := builder.Step{"git", "clone"}
+	if o.Submodules {
+		clone = append(clone, "--recurse-submodules")
+	}
+
+	steps := []gitStep{
+		{step: append(clone, o.URL, ".")},
+		{step: builder.Step{"git", "fetch"}},
+		{step: builder.Step{"git", "reset", "--hard", o.Head}, kind: ErrRevision},
+		{step: builder.Step{"git", "clean", "-f", "-d", "-x"}},
+	}
+
+	if o.VerifySigned {
+		verify := builder.Step{"git"}
+		if o.AllowedSigners != "" {
+			verify = append(verify, "-c", "gpg.ssh.allowedSignersFile="+o.AllowedSigners)
+		}
+		steps = append(steps, gitStep{step: append(verify, "verify-commit", o.Head), kind: ErrSignature})
+	}
+
+	if o.Submodules {
+		steps = append(steps, gitStep{step: builder.Step{"git", "submodule", "update", "--init", "--recursive"}})
+	}
+
+	for _, s := range steps {
+		if err := builder.Run(ctx, dir, o.Env, o.Out, s.step); err != nil {
+			// A cancelled command says nothing about the repository.
+			kind := s.kind
+			if ctx.Err() != nil {
+				kind = nil
+			}
+
+			return &Error{Op: s.step.Name(), Kind: kind, Err: err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package vcs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// GoGit checks out with go-git, so no git binary is needed. Signatures
+// can't be verified with it.
+type GoGit struct{}
+
+// Checkout clones o.URL into dir and resets it to o.Head.
+func (GoGit) Checkout(ctx context.Context, dir string, o Options) error {
+	if o.VerifySigned {
+		return &Error{Op: "verify", Kind: ErrUnsupported, Err: errors.New("go-git can't verify signatures, use the git binary")}
+	}
+
+	recurse := git.NoRecurseSubmodules
+	if o.Submodules {
+		recurse = git.DefaultSubmoduleRecursionDepth
+	}
+
+	r, err := git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
+		URL:      o.URL,
+		Progress: o.Out,
+	})
+	if err != nil {
+		return &Error{Op: "clone", Err: err}
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return &Error{Op: "worktree", Err: err}
+	}
+
+	if err := w.Reset(&git.ResetOptions{Commit: plumbing.NewHash(o.Head), Mode: git.HardReset}); err != nil {
+		var kind error
+		if errors.Is(err, plumbing.ErrObjectNotFound) || errors.Is(err, plumbing.ErrReferenceNotFound) {
+			kind = ErrRevision
+		}
+
+		return &Error{Op: "reset " + o.Head, Kind: kind, Err: err}
+	}
+
+	if recurse == git.NoRecurseSubmodules {
+		return nil
+	}
+
+	subs, err := w.Submodules()
+	if err != nil {
+		return &Error{Op: "submodules", Err: err}
+	}
+
+	if err := subs.UpdateContext(ctx, &git.SubmoduleUpdateOptions{Init: true, RecurseSubmodules: recurse}); err != nil {
+		return &Error{Op: "submodule update", Err: err}
+	}
+
+	return nil
+}
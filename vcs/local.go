@@ -0,0 +1,90 @@
+package vcs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local copies a working tree on disk instead of checking out a commit,
+// for development with -watch-dir. URL and Head of the options are
+// ignored.
+type Local struct {
+	Root string
+}
+
+// Checkout copies the tree under l.Root into dir. Hidden files and
+// directories, .git among them, are skipped.
+func (l Local) Checkout(ctx context.Context, dir string, o Options) error {
+	if o.VerifySigned {
+		return &Error{Op: "verify", Kind: ErrUnsupported, Err: errors.New("a local tree has no signatures")}
+	}
+
+	err := filepath.Walk(l.Root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(l.Root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		if strings.HasPrefix(fi.Name(), ".") {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		dst := filepath.Join(dir, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(dst, fi.Mode().Perm()|0700)
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
+		case fi.Mode().IsRegular():
+			return copyFile(path, dst, fi.Mode().Perm())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return &Error{Op: "copy " + l.Root, Err: err}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
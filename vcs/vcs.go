@@ -0,0 +1,77 @@
+// Package vcs checks out the commit being deployed, with the git binary or
+// with go-git where there is none.
+package vcs
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of failures, see Error.
+var (
+	ErrRevision    = errors.New("unknown revision")
+	ErrSignature   = errors.New("no valid signature")
+	ErrUnsupported = errors.New("not supported")
+)
+
+// Error is a failed operation. Kind is one of the Err values or nil, so
+// failures can be told apart with errors.Is.
+type Error struct {
+	Op   string
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+// Unwrap returns the kind and the cause of e.
+func (e *Error) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+
+	return []error{e.Kind, e.Err}
+}
+
+// Options describe a checkout.
+type Options struct {
+	// URL is the repository, Head the commit checked out.
+	URL  string
+	Head string
+
+	// Submodules checks out the submodules of the repository too.
+	Submodules bool
+
+	// VerifySigned refuses a Head without a valid signature, checked
+	// against the SSH AllowedSigners file when set and the GnuPG keyring
+	// otherwise.
+	VerifySigned   bool
+	AllowedSigners string
+
+	// Env is the environment of commands run, Out gets their output.
+	Env []string
+	Out io.Writer
+}
+
+// VCS checks out commits.
+type VCS interface {
+	// Checkout puts Head of o into the empty directory dir.
+	Checkout(ctx context.Context, dir string, o Options) error
+}
+
+// New returns the VCS named kind: git runs the git binary, go-git needs
+// none.
+func New(kind string) (VCS, error) {
+	switch kind {
+	case "", "git":
+		return Git{}, nil
+	case "go-git":
+		return GoGit{}, nil
+	}
+
+	return nil, errors.Errorf("unknown vcs %q", kind)
+}
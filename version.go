@@ -0,0 +1,42 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/romanyx/watcher/deployer"
+)
+
+// Set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// Unset values are taken from the VCS stamp of the Go toolchain when
+// there is one.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// watcherBuild returns the build info of the running watcher.
+func watcherBuild() deployer.BuildInfo {
+	bi := deployer.BuildInfo{Version: version, Commit: commit, BuildDate: buildDate, Go: runtime.Version()}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if bi.Version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			bi.Version = info.Main.Version
+		}
+
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && bi.Commit == "":
+				bi.Commit = s.Value
+			case s.Key == "vcs.time" && bi.BuildDate == "":
+				bi.BuildDate = s.Value
+			}
+		}
+	}
+
+	return bi
+}
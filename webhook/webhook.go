@@ -0,0 +1,205 @@
+// Package webhook receives GitHub push events.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxBody is the largest body read by a Handler without MaxBody,
+// GitHub does not send larger payloads.
+const DefaultMaxBody = 25 << 20
+
+// Errors about rejected requests, see Handler.OnError.
+var (
+	ErrSignature = errors.New("wrong signature")
+	ErrTooLarge  = errors.New("body too large")
+	ErrMediaType = errors.New("unsupported content type")
+)
+
+// Push is a push to a GitHub repository.
+type Push struct {
+	// Ref is the pushed ref like refs/heads/master, Head the commit it
+	// points to now.
+	Ref  string
+	Head string
+
+	// Repo is the full name of the repository, owner/name.
+	Repo string
+
+	// Deleted is set when the ref was deleted, Head is zero then.
+	Deleted bool
+}
+
+// Verify reports whether signature, the value of X-Hub-Signature-256 or
+// X-Hub-Signature, signs body with secret. An empty secret signs nothing.
+func Verify(secret string, body []byte, signature string) bool {
+	if secret == "" {
+		return false
+	}
+
+	var h hash.Hash
+	switch {
+	case strings.HasPrefix(signature, "sha256="):
+		h = hmac.New(sha256.New, []byte(secret))
+	case strings.HasPrefix(signature, "sha1="):
+		h = hmac.New(sha1.New, []byte(secret))
+	default:
+		return false
+	}
+	h.Write(body)
+
+	prefix := signature[:strings.Index(signature, "=")+1]
+	want := prefix + hex.EncodeToString(h.Sum(nil))
+
+	return hmac.Equal([]byte(signature), []byte(want))
+}
+
+// ParsePush parses the JSON payload of a push event. It fails when the
+// ref or the head is missing.
+func ParsePush(body []byte) (Push, error) {
+	ev := struct {
+		Ref        string `json:"ref"`
+		Head       string `json:"after"`
+		Deleted    bool   `json:"deleted"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}{}
+
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return Push{}, errors.Wrap(err, "unmarshal push")
+	}
+
+	if ev.Ref == "" || ev.Head == "" {
+		return Push{}, errors.New("push without ref or head")
+	}
+
+	return Push{Ref: ev.Ref, Head: ev.Head, Repo: ev.Repository.FullName, Deleted: ev.Deleted}, nil
+}
+
+// payload returns the JSON payload of a body of mediaType, which GitHub
+// sends as is or as the payload field of a form.
+func payload(mediaType string, body []byte) ([]byte, error) {
+	switch mediaType {
+	case "application/json":
+		return body, nil
+	case "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, errors.Wrap(err, "parse form")
+		}
+		return []byte(form.Get("payload")), nil
+	}
+
+	return nil, ErrMediaType
+}
+
+// Handler serves the endpoint GitHub posts push events to.
+type Handler struct {
+	// Secret is the secret of the webhook.
+	Secret string
+
+	// OnPush is called with every verified push and answers it.
+	OnPush func(w http.ResponseWriter, r *http.Request, p Push)
+
+	// OnError, when set, is told about requests which can't be read or
+	// are not verified, the latter with ErrSignature.
+	OnError func(r *http.Request, err error)
+
+	// MaxBody limits the body read, DefaultMaxBody when 0.
+	MaxBody int64
+}
+
+// ServeHTTP reads, verifies and parses the event in r and hands it to
+// OnPush. Other events are acknowledged with 202, pings with 200. Requests
+// are answered with 405 for a method other than POST, 415 for a content
+// type other than JSON or form, 413 for a body beyond MaxBody, 401 for a
+// wrong signature and 400 for a malformed push.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && mediaType != "application/x-www-form-urlencoded") {
+		h.fail(r, ErrMediaType)
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	limit := h.MaxBody
+	if limit <= 0 {
+		limit = DefaultMaxBody
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.fail(r, ErrTooLarge)
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		h.fail(r, errors.Wrap(err, "read body"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	signature := r.Header.Get("X-Hub-Signature-256")
+	if signature == "" {
+		signature = r.Header.Get("X-Hub-Signature")
+	}
+
+	if !Verify(h.Secret, body, signature) {
+		h.fail(r, ErrSignature)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Header.Get("X-GitHub-Event") {
+	case "", "push":
+	case "ping":
+		io.WriteString(w, "pong")
+		return
+	default:
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	data, err := payload(mediaType, body)
+	if err != nil {
+		h.fail(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	push, err := ParsePush(data)
+	if err != nil {
+		h.fail(r, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	h.OnPush(w, r, push)
+}
+
+func (h *Handler) fail(r *http.Request, err error) {
+	if h.OnError != nil {
+		h.OnError(r, err)
+	}
+}